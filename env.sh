@@ -3,8 +3,32 @@ export TOKEN=
 
 export RECIPIENT=
 
+# YAML file of storage, router and strategies (see config.example.yaml), which is overridden by variables below
+export CONFIG_FILE=
+
+# firestore (default), sqlite, postgres, bolt, redis or memory
+export STORAGE_BACKEND=
+# mailgun (default) or mock
+export ROUTER_BACKEND=
+
+export SQLITE_PATH=
+export POSTGRES_DSN=
+export BOLT_PATH=
+export REDIS_URL=
+export REDIS_PREFIX=
+
+# base64-encoded 32 bytes key, which enables encryption of site keys and metadata at rest
+export STORAGE_ENCRYPTION_KEY=
+export STORAGE_ENCRYPTION_KEY_FILE=
+
+# number of records cached in memory, and how long they are (1m if empty)
+export STORAGE_CACHE_SIZE=
+export STORAGE_CACHE_TTL=
+
 export GCP_PROJECT=private-email-relay
 export GCP_FIRESTORE_COLLECTION=private-email-relay
 
 export MG_DOMAIN=
 export MG_API_KEY=
+# HTTP webhook signing key, which accepts POST /webhook/delivery forwarded by Mailgun without TOKEN
+export MG_WEBHOOK_SIGNING_KEY=
@@ -4,9 +4,15 @@ go 1.16
 
 require (
 	cloud.google.com/go/firestore v1.5.0
+	github.com/alicebob/miniredis/v2 v2.14.5
+	github.com/go-redis/redis/v8 v8.11.3
 	github.com/labstack/echo/v4 v4.3.0
+	github.com/lib/pq v1.10.2
 	github.com/mailgun/mailgun-go/v4 v4.5.1
 	github.com/stretchr/testify v1.7.0
+	go.etcd.io/bbolt v1.3.6
 	golang.org/x/net v0.0.0-20210614182718-04defd469f4e
 	google.golang.org/grpc v1.38.0
+	gopkg.in/yaml.v3 v3.0.1
+	modernc.org/sqlite v1.11.2
 )
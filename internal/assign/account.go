@@ -0,0 +1,65 @@
+package assign
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	maxAccountLength = 32
+)
+
+var (
+	ErrorInvalidAccount = fmt.Errorf("invalid account")
+)
+
+func isAccountChar(c rune) bool {
+	return ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') || c == '-' || c == '_'
+}
+
+// normalizeAccount lowercases account, which consists of alphanumerics, '-' and '_'.
+// returns ErrorInvalidAccount
+func normalizeAccount(account string) (string, error) {
+	account = strings.ToLower(account)
+	if account == "" || len(account) > maxAccountLength {
+		return "", fmt.Errorf("%w: must be 1..%d characters: %q", ErrorInvalidAccount, maxAccountLength, account)
+	}
+	for _, c := range account {
+		if !isAccountChar(c) {
+			return "", fmt.Errorf("%w: character %q is not allowed: %q", ErrorInvalidAccount, c, account)
+		}
+	}
+	return account, nil
+}
+
+// scopeKey prepends account to key as "account@key".
+func scopeKey(account, key string) string {
+	if account == "" {
+		return key
+	}
+	return account + "@" + key
+}
+
+// ParseAccount returns account of key, or empty if key is not scoped.
+func ParseAccount(key string) string {
+	if i := strings.Index(key, "#"); i >= 0 {
+		key = key[i+1:]
+	}
+	account, _ := SplitAccount(key)
+	return account
+}
+
+// SplitAccount separates account from key without namespace, such as "team@example.com".
+// Keys never contain '@' before '/' unless scoped, and accounts never contain '.' nor '/', so that it is unambiguous.
+func SplitAccount(key string) (account string, rest string) {
+	i := strings.Index(key, "@")
+	if i <= 0 {
+		return "", key
+	}
+	for _, c := range key[:i] {
+		if !isAccountChar(c) {
+			return "", key
+		}
+	}
+	return key[:i], key[i+1:]
+}
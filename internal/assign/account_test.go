@@ -0,0 +1,110 @@
+package assign_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/kaz/private-email-relay/internal/assign"
+	"github.com/kaz/private-email-relay/internal/router"
+	"github.com/kaz/private-email-relay/internal/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestForAccount(t *testing.T) {
+	for name, impl := range implements {
+		t.Run(name, func(t *testing.T) {
+			testForAccount(t, impl)
+		})
+	}
+}
+func testForAccount(t *testing.T, s assign.Strategy) {
+	url := "https://www.testForAccount.test/login"
+	scoped := s.(assign.AccountScoped)
+
+	personal, err := scoped.ForAccount("personal")
+	assert.NoError(t, err)
+	team, err := scoped.ForAccount("Team")
+	assert.NoError(t, err)
+
+	records := map[string]*storage.Record{}
+	for account, strategy := range map[string]assign.Strategy{"": s, "personal": personal, "team": team} {
+		records[account], err = strategy.Assign(ctx, url, storage.Metadata{})
+		assert.NoError(t, err)
+		assert.Equal(t, account, assign.ParseAccount(records[account].Key))
+
+		// every account belongs to the same site
+		domain, ok := s.ParseKey(records[account].Key)
+		assert.True(t, ok)
+		assert.Equal(t, "testForAccount.test", domain)
+	}
+	assert.NotEqual(t, records[""].Value, records["personal"].Value)
+	assert.NotEqual(t, records["personal"].Value, records["team"].Value)
+
+	site, err := scoped.Site(url)
+	assert.NoError(t, err)
+	assert.Equal(t, "testForAccount.test", site)
+
+	_, err = team.Unassign(ctx, url)
+	assert.NoError(t, err)
+	_, err = team.Lookup(ctx, url)
+	assert.True(t, errors.Is(err, storage.ErrorUndefinedKey))
+
+	looked, err := personal.Lookup(ctx, url)
+	assert.NoError(t, err)
+	assert.Equal(t, records["personal"].Value, looked.Value)
+}
+
+func TestForAccountInvalid(t *testing.T) {
+	s, ok := implements["default"].(assign.AccountScoped)
+	if !ok {
+		t.Skip("skip default")
+	}
+
+	for _, account := range []string{"", "a.b", "a@b", "a/b", "a#b", "ünicode", "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"} {
+		_, err := s.ForAccount(account)
+		assert.True(t, errors.Is(err, assign.ErrorInvalidAccount), account)
+	}
+}
+
+func TestDeterministicForAccount(t *testing.T) {
+	s := newDeterministicStrategy(t, storage.NewMemoryStorage(), router.NewMockRouter(), "testDeterministicSecret")
+	url := "https://testDeterministicForAccount.test"
+
+	team, err := s.ForAccount("team")
+	assert.NoError(t, err)
+
+	plain, err := s.Assign(ctx, url, storage.Metadata{})
+	assert.NoError(t, err)
+	scoped, err := team.Assign(ctx, url, storage.Metadata{})
+	assert.NoError(t, err)
+	assert.NotEqual(t, plain.Value, scoped.Value)
+
+	// derived from the account as well as the domain
+	again, err := newDeterministicStrategy(t, storage.NewMemoryStorage(), router.NewMockRouter(), "testDeterministicSecret").ForAccount("team")
+	assert.NoError(t, err)
+	record, err := again.Assign(ctx, url, storage.Metadata{})
+	assert.NoError(t, err)
+	assert.Equal(t, scoped.Value, record.Value)
+}
+
+func TestSplitAccount(t *testing.T) {
+	cases := []struct {
+		key     string
+		account string
+		rest    string
+	}{
+		{"example.com", "", "example.com"},
+		{"team@example.com", "team", "example.com"},
+		{"medium.com/@kaz", "", "medium.com/@kaz"},
+		{"team@medium.com/@kaz", "team", "medium.com/@kaz"},
+		{"@example.com", "", "@example.com"},
+	}
+	for _, c := range cases {
+		account, rest := assign.SplitAccount(c.key)
+		assert.Equal(t, c.account, account, c.key)
+		assert.Equal(t, c.rest, rest, c.key)
+	}
+
+	assert.Equal(t, "team", assign.ParseAccount("temp#team@example.com"))
+	assert.Equal(t, "", assign.ParseAccount("temp#example.com"))
+}
@@ -0,0 +1,147 @@
+package assign
+
+import (
+	"context"
+	_ "embed"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/kaz/private-email-relay/internal/storage"
+)
+
+const (
+	// RFC 5321 4.5.3.1.1
+	maxLocalPartLength = 64
+
+	// a subset of atext of RFC 5322 besides alphanumerics, which makes up dot-string of RFC 5321 with ".".
+	// the rest of atext, such as "+" and "|", have special meanings in routes of Mailgun or mail providers.
+	aliasSymbols = "-_"
+)
+
+var (
+	ErrorInvalidAlias = fmt.Errorf("invalid alias")
+
+	// role accounts of RFC 2142, and ones which mail providers treat specially
+	reservedAliases = map[string]bool{
+		"abuse": true, "admin": true, "administrator": true, "ftp": true, "hostmaster": true,
+		"info": true, "mailer-daemon": true, "marketing": true, "news": true, "noc": true,
+		"nobody": true, "no-reply": true, "noreply": true, "postmaster": true, "root": true,
+		"sales": true, "security": true, "support": true, "usenet": true, "uucp": true,
+		"webmaster": true, "www": true,
+	}
+
+	//go:embed blocklist.txt
+	blocklistFile string
+	blocklist     = wordSet(strings.Fields(blocklistFile))
+)
+
+func wordSet(words []string) map[string]bool {
+	set := map[string]bool{}
+	for _, word := range words {
+		if word = strings.ToLower(strings.TrimSpace(word)); word != "" {
+			set[word] = true
+		}
+	}
+	return set
+}
+
+func isAliasChar(c rune) bool {
+	return ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9') || strings.ContainsRune(aliasSymbols, c)
+}
+
+// ValidateAlias checks alias is a dot-string local part of RFC 5321 of alphanumerics, "-" and "_", and is neither reserved nor blocked.
+// Quoted-string local parts are not accepted, since few services can send mails to them.
+// returns ErrorInvalidAlias
+func ValidateAlias(alias string, blocked map[string]bool) error {
+	if err := validateLocalPart(alias); err != nil {
+		return err
+	}
+
+	lower := strings.ToLower(alias)
+	if reservedAliases[lower] {
+		return fmt.Errorf("%w: reserved: %v", ErrorInvalidAlias, alias)
+	}
+
+	// words are compared one by one, not to reject innocent ones containing blocked words
+	words := strings.FieldsFunc(lower, func(c rune) bool {
+		return !('a' <= c && c <= 'z') && !('0' <= c && c <= '9')
+	})
+	for _, word := range append(words, strings.Join(words, "")) {
+		if blocklist[word] || blocked[word] {
+			return fmt.Errorf("%w: blocked word: %v", ErrorInvalidAlias, alias)
+		}
+	}
+	return nil
+}
+
+// validateLocalPart checks the syntax of ValidateAlias only, since addresses assigned before reserved or blocked words must still pass.
+func validateLocalPart(alias string) error {
+	if alias == "" {
+		return fmt.Errorf("%w: empty", ErrorInvalidAlias)
+	}
+	if len(alias) > maxLocalPartLength {
+		return fmt.Errorf("%w: longer than %d characters: %v", ErrorInvalidAlias, maxLocalPartLength, alias)
+	}
+	for _, atom := range strings.Split(alias, ".") {
+		if atom == "" {
+			return fmt.Errorf("%w: leading, trailing or consecutive dots: %v", ErrorInvalidAlias, alias)
+		}
+		for _, c := range atom {
+			if !isAliasChar(c) {
+				return fmt.Errorf("%w: character %q is not allowed: %v", ErrorInvalidAlias, c, alias)
+			}
+		}
+	}
+	return nil
+}
+
+// assignAlias assigns the given address, instead of producing one.
+// returns ErrorInvalidAlias, storage.ErrorDuplicatedKey, storage.ErrorDuplicatedValue, router.ErrorDuplicated
+func (s *baseStrategy) assignAlias(ctx context.Context, keyProd producer, alias string, blocked map[string]bool, expires time.Time, meta storage.Metadata) (*storage.Record, error) {
+	if err := ValidateAlias(alias, blocked); err != nil {
+		return nil, err
+	}
+	// mail providers mostly ignore case, so that aliases differing in case are the same
+	addr := fmt.Sprintf("%s@%s", strings.ToLower(alias), s.emailDomain)
+
+	key, err := keyProd()
+	if err != nil {
+		return nil, fmt.Errorf("failed to produce key: %w", err)
+	}
+
+	record, err := s.store.Get(ctx, key)
+	if err == nil {
+		if record.Value == addr {
+			return record, nil
+		}
+		return nil, fmt.Errorf("%w: site is assigned another address %v: key=%v", storage.ErrorDuplicatedKey, record.Value, key)
+	} else if !errors.Is(err, storage.ErrorUndefinedKey) {
+		return nil, fmt.Errorf("failed to get value from storage: %w", err)
+	}
+
+	now := time.Now()
+	record = &storage.Record{
+		Key:           key,
+		Value:         addr,
+		Expires:       expires,
+		MaxDeliveries: s.maxDeliveries,
+		Metadata:      meta,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+
+	if err := s.store.Set(ctx, record); err != nil {
+		return nil, fmt.Errorf("failed to write to storage: %w", err)
+	}
+	// routes created by someone else are reported as router.ErrorDuplicated
+	if err := s.setRoute(ctx, addr, s.recipientAddr); err != nil {
+		if _, rollbackErr := s.store.UnsetByKey(ctx, key); rollbackErr != nil {
+			return nil, fmt.Errorf("failed to create route: %v, and failed to rollback storage: %w", err, rollbackErr)
+		}
+		return nil, fmt.Errorf("failed to create route: %w", err)
+	}
+
+	return record, nil
+}
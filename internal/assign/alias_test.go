@@ -0,0 +1,104 @@
+package assign_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/kaz/private-email-relay/internal/assign"
+	"github.com/kaz/private-email-relay/internal/router"
+	"github.com/kaz/private-email-relay/internal/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateAlias(t *testing.T) {
+	valid := []string{
+		"github",
+		"GitHub",
+		"john.doe",
+		"shop-2021",
+		"a_b-c",
+		"-_",
+		"assassin",
+		strings.Repeat("a", 64),
+	}
+	for _, alias := range valid {
+		assert.NoError(t, assign.ValidateAlias(alias, nil), alias)
+	}
+
+	invalid := []string{
+		"",
+		strings.Repeat("a", 65),
+		".github",
+		"github.",
+		"git..hub",
+		"git hub",
+		"git@hub",
+		`"github"`,
+		"git(hub)",
+		"gïthub",
+		// metacharacters of regular expressions in routes
+		"a.+",
+		"shop+2021",
+		"a*",
+		"^github$",
+		"git|hub",
+		"git{2}hub",
+		"git?hub",
+		"git/hub",
+		"!#$%&'*+=?^`{|}~",
+		"postmaster",
+		"Abuse",
+		"no-reply",
+		"shit",
+		"holy.shit",
+		"my-Fuck-list",
+		"bull.shit",
+		"competitor",
+	}
+	for _, alias := range invalid {
+		err := assign.ValidateAlias(alias, map[string]bool{"competitor": true})
+		assert.True(t, errors.Is(err, assign.ErrorInvalidAlias), alias)
+	}
+}
+
+func TestAssignAlias(t *testing.T) {
+	route := router.NewMockRouter()
+	s, err := assign.NewConfigurableStrategy(storage.NewMemoryStorage(), route, assign.StrategyOptions{Length: 8, Blocklist: []string{"competitor"}})
+	if err != nil {
+		t.Skipf("skip configurable: %v", err)
+	}
+	url := "https://github.com/kaz/private-email-relay"
+
+	assigned, err := s.AssignAlias(ctx, url, "GitHub", storage.Metadata{})
+	assert.NoError(t, err)
+	assert.Equal(t, address("github"), assigned.Value)
+
+	// the same alias again is not a conflict
+	again, err := s.AssignAlias(ctx, url, "github", storage.Metadata{})
+	assert.NoError(t, err)
+	assert.Equal(t, assigned.Value, again.Value)
+
+	looked, err := s.Lookup(ctx, url)
+	assert.NoError(t, err)
+	assert.Equal(t, assigned.Value, looked.Value)
+
+	// another alias for the assigned site
+	_, err = s.AssignAlias(ctx, url, "octocat", storage.Metadata{})
+	assert.True(t, errors.Is(err, storage.ErrorDuplicatedKey))
+
+	// the alias is taken by another site
+	_, err = s.AssignAlias(ctx, "https://gitlab.com", "github", storage.Metadata{})
+	assert.True(t, errors.Is(err, storage.ErrorDuplicatedValue))
+
+	// the alias is routed by someone else
+	err = route.Set(ctx, address("gitlab"), "someone@test.test")
+	assert.NoError(t, err)
+	_, err = s.AssignAlias(ctx, "https://gitlab.com", "gitlab", storage.Metadata{})
+	assert.True(t, errors.Is(err, router.ErrorDuplicated))
+	_, err = s.Lookup(ctx, "https://gitlab.com")
+	assert.True(t, errors.Is(err, storage.ErrorUndefinedKey))
+
+	_, err = s.AssignAlias(ctx, "https://gitlab.com", "competitor", storage.Metadata{})
+	assert.True(t, errors.Is(err, assign.ErrorInvalidAlias))
+}
@@ -2,12 +2,52 @@ package assign
 
 import (
 	"context"
+	"time"
+
+	"github.com/kaz/private-email-relay/internal/storage"
 )
 
 type (
 	Strategy interface {
-		Assign(ctx context.Context, url string) (assignedAddr string, err error)
-		Unassign(ctx context.Context, url string) error
-		UnassignByAddr(ctx context.Context, addr string) error
+		Assign(ctx context.Context, url string, meta storage.Metadata) (assigned *storage.Record, err error)
+		// returns storage.ErrorUndefinedKey
+		Lookup(ctx context.Context, url string) (assigned *storage.Record, err error)
+		Unassign(ctx context.Context, url string) (unassigned *storage.Record, err error)
+		UnassignByAddr(ctx context.Context, addr string) (unassigned *storage.Record, err error)
+		// reports the domain of a storage key if the key belongs to this strategy
+		ParseKey(key string) (domain string, ok bool)
+	}
+
+	// AliasAssigner assigns an address chosen by user, such as "github@domain"
+	AliasAssigner interface {
+		// returns ErrorInvalidAlias, storage.ErrorDuplicatedKey, storage.ErrorDuplicatedValue, router.ErrorDuplicated
+		AssignAlias(ctx context.Context, url string, alias string, meta storage.Metadata) (assigned *storage.Record, err error)
+	}
+
+	// AccountScoped tells apart aliases of several accounts on the same site, such as personal and team ones.
+	AccountScoped interface {
+		// returns a strategy whose keys include account. returns ErrorInvalidAccount
+		ForAccount(account string) (scoped Strategy, err error)
+		// returns the domain of url, which keys of every account share
+		Site(url string) (domain string, err error)
+	}
+
+	// DeliveryCounter counts mails delivered to addresses, and unassigns ones which reached the limit of deliveries.
+	DeliveryCounter interface {
+		// returns storage.ErrorUndefinedValue
+		CountDelivery(ctx context.Context, addr string) (counted *storage.Record, unassigned bool, err error)
+	}
+
+	// ExpiryUpdater changes when addresses expire, such as extending temporary ones or making them permanent by storage.NeverExpire.
+	ExpiryUpdater interface {
+		// returns storage.ErrorUndefinedKey
+		UpdateExpiry(ctx context.Context, url string, expires time.Time) (updated *storage.Record, err error)
+		// returns storage.ErrorUndefinedValue, also for addresses of other strategies or accounts
+		UpdateExpiryByAddr(ctx context.Context, addr string, expires time.Time) (updated *storage.Record, err error)
+	}
+
+	Expirer interface {
+		// returns the number of unassigned addresses
+		UnassignExpired(ctx context.Context, until time.Time) (count int, err error)
 	}
 )
@@ -2,7 +2,9 @@ package assign_test
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"os"
 	"testing"
 	"time"
 
@@ -18,6 +20,11 @@ var (
 	implements = map[string]assign.Strategy{}
 )
 
+// address returns the address of local part on MG_DOMAIN, where strategies assign addresses
+func address(local string) string {
+	return local + "@" + os.Getenv("MG_DOMAIN")
+}
+
 func TestMain(m *testing.M) {
 	var err error
 
@@ -36,6 +43,12 @@ func TestMain(m *testing.M) {
 		delete(implements, "temporary")
 	}
 
+	implements["burner"], err = assign.NewBurnerStrategy(store, route, 2)
+	if err != nil {
+		fmt.Printf("[[WARNING]] skip burner: %v", err)
+		delete(implements, "burner")
+	}
+
 	m.Run()
 }
 
@@ -51,17 +64,17 @@ func testAssignDifferentSite(t *testing.T, s assign.Strategy) {
 		"https://www.youtube.com/watch?v=mZ0sJQC8qkE",
 		"https://github.com/kaz/private-email-relay",
 	}
-	addrs := make([]string, len(urls))
+	addrs := make([]*storage.Record, len(urls))
 
 	var err error
 
-	addrs[0], err = s.Assign(ctx, urls[0])
+	addrs[0], err = s.Assign(ctx, urls[0], storage.Metadata{})
 	assert.NoError(t, err)
 
-	addrs[1], err = s.Assign(ctx, urls[1])
+	addrs[1], err = s.Assign(ctx, urls[1], storage.Metadata{})
 	assert.NoError(t, err)
 
-	assert.NotEqual(t, addrs[0], addrs[1])
+	assert.NotEqual(t, addrs[0].Value, addrs[1].Value)
 }
 
 func TestAssignExactlySameSite(t *testing.T) {
@@ -77,17 +90,17 @@ func testAssignExactlySameSite(t *testing.T, s assign.Strategy) {
 		"https://www.youtube.com/watch?v=mZ0sJQC8qkE",
 		"https://www.youtube.com/watch?v=i-b1lfCWGmc",
 	}
-	addrs := make([]string, len(urls))
+	addrs := make([]*storage.Record, len(urls))
 
 	var err error
 
-	addrs[0], err = s.Assign(ctx, urls[0])
+	addrs[0], err = s.Assign(ctx, urls[0], storage.Metadata{})
 	assert.NoError(t, err)
 
-	addrs[1], err = s.Assign(ctx, urls[1])
+	addrs[1], err = s.Assign(ctx, urls[1], storage.Metadata{})
 	assert.NoError(t, err)
 
-	assert.Equal(t, addrs[0], addrs[1])
+	assert.Equal(t, addrs[0].Value, addrs[1].Value)
 }
 
 func TestAssignEffectivelySameSite(t *testing.T) {
@@ -102,17 +115,17 @@ func testAssignEffectivelySameSite(t *testing.T, s assign.Strategy) {
 		"https://www.youtube.com/watch?v=mZ0sJQC8qkE",
 		"https://music.youtube.com/channel/UCuCfKSM0_23RRXxQGYTVJlw",
 	}
-	addrs := make([]string, len(urls))
+	addrs := make([]*storage.Record, len(urls))
 
 	var err error
 
-	addrs[0], err = s.Assign(ctx, urls[0])
+	addrs[0], err = s.Assign(ctx, urls[0], storage.Metadata{})
 	assert.NoError(t, err)
 
-	addrs[1], err = s.Assign(ctx, urls[1])
+	addrs[1], err = s.Assign(ctx, urls[1], storage.Metadata{})
 	assert.NoError(t, err)
 
-	assert.Equal(t, addrs[0], addrs[1])
+	assert.Equal(t, addrs[0].Value, addrs[1].Value)
 }
 
 func TestAssignConfusingDifferentSite(t *testing.T) {
@@ -127,17 +140,17 @@ func testAssignConfusingDifferentSite(t *testing.T, s assign.Strategy) {
 		"https://kaz.github.io",
 		"https://sekai67.github.io",
 	}
-	addrs := make([]string, len(urls))
+	addrs := make([]*storage.Record, len(urls))
 
 	var err error
 
-	addrs[0], err = s.Assign(ctx, urls[0])
+	addrs[0], err = s.Assign(ctx, urls[0], storage.Metadata{})
 	assert.NoError(t, err)
 
-	addrs[1], err = s.Assign(ctx, urls[1])
+	addrs[1], err = s.Assign(ctx, urls[1], storage.Metadata{})
 	assert.NoError(t, err)
 
-	assert.NotEqual(t, addrs[0], addrs[1])
+	assert.NotEqual(t, addrs[0].Value, addrs[1].Value)
 }
 
 func TestUnassign(t *testing.T) {
@@ -150,16 +163,16 @@ func TestUnassign(t *testing.T) {
 func testUnassign(t *testing.T, s assign.Strategy) {
 	url := "http://testUnassign.test"
 
-	addr1, err := s.Assign(ctx, url)
+	addr1, err := s.Assign(ctx, url, storage.Metadata{})
 	assert.NoError(t, err)
 
-	err = s.Unassign(ctx, url)
+	_, err = s.Unassign(ctx, url)
 	assert.NoError(t, err)
 
-	addr2, err := s.Assign(ctx, url)
+	addr2, err := s.Assign(ctx, url, storage.Metadata{})
 	assert.NoError(t, err)
 
-	assert.NotEqual(t, addr1, addr2)
+	assert.NotEqual(t, addr1.Value, addr2.Value)
 }
 
 func TestUnassignByAddr(t *testing.T) {
@@ -172,16 +185,16 @@ func TestUnassignByAddr(t *testing.T) {
 func testUnassignByAddr(t *testing.T, s assign.Strategy) {
 	url := "http://testUnassignByAddr.test"
 
-	addr1, err := s.Assign(ctx, url)
+	addr1, err := s.Assign(ctx, url, storage.Metadata{})
 	assert.NoError(t, err)
 
-	err = s.UnassignByAddr(ctx, addr1)
+	_, err = s.UnassignByAddr(ctx, addr1.Value)
 	assert.NoError(t, err)
 
-	addr2, err := s.Assign(ctx, url)
+	addr2, err := s.Assign(ctx, url, storage.Metadata{})
 	assert.NoError(t, err)
 
-	assert.NotEqual(t, addr1, addr2)
+	assert.NotEqual(t, addr1.Value, addr2.Value)
 }
 
 func TestUnassignUndefined(t *testing.T) {
@@ -194,7 +207,7 @@ func TestUnassignUndefined(t *testing.T) {
 func testUnassignUndefined(t *testing.T, s assign.Strategy) {
 	url := "http://testUnassignUndefined.test"
 
-	err := s.Unassign(ctx, url)
+	_, err := s.Unassign(ctx, url)
 	assert.Error(t, err)
 }
 
@@ -208,7 +221,7 @@ func TestUnassignByAddrUndefined(t *testing.T) {
 func testUnassignByAddrUndefined(t *testing.T, s assign.Strategy) {
 	addr := "testUnassignByAddrUndefined@test.test"
 
-	err := s.UnassignByAddr(ctx, addr)
+	_, err := s.UnassignByAddr(ctx, addr)
 	assert.Error(t, err)
 }
 
@@ -228,7 +241,7 @@ func testAssignEdgeSuccess(t *testing.T, s assign.Strategy) {
 	}
 
 	for _, url := range urls {
-		_, err := s.Assign(ctx, url)
+		_, err := s.Assign(ctx, url, storage.Metadata{})
 		assert.NoError(t, err)
 	}
 }
@@ -248,7 +261,74 @@ func testAssignEdgeFail(t *testing.T, s assign.Strategy) {
 	}
 
 	for _, url := range urls {
-		_, err := s.Assign(ctx, url)
+		_, err := s.Assign(ctx, url, storage.Metadata{})
 		assert.Error(t, err)
 	}
 }
+
+func TestLookup(t *testing.T) {
+	for name, impl := range implements {
+		t.Run(name, func(t *testing.T) {
+			testLookup(t, impl)
+		})
+	}
+}
+func testLookup(t *testing.T, s assign.Strategy) {
+	urls := []string{
+		"http://www.testLookup.test/foo",
+		"http://mail.testLookup.test/bar",
+	}
+
+	_, err := s.Lookup(ctx, urls[0])
+	assert.Error(t, err)
+	assert.True(t, errors.Is(err, storage.ErrorUndefinedKey))
+
+	assigned, err := s.Assign(ctx, urls[0], storage.Metadata{})
+	assert.NoError(t, err)
+
+	for _, url := range urls {
+		got, err := s.Lookup(ctx, url)
+		assert.NoError(t, err)
+		assert.Equal(t, assigned.Value, got.Value)
+	}
+
+	_, err = s.Unassign(ctx, urls[0])
+	assert.NoError(t, err)
+
+	_, err = s.Lookup(ctx, urls[0])
+	assert.Error(t, err)
+	assert.True(t, errors.Is(err, storage.ErrorUndefinedKey))
+}
+
+func TestAssignMetadata(t *testing.T) {
+	for name, impl := range implements {
+		t.Run(name, func(t *testing.T) {
+			testAssignMetadata(t, impl)
+		})
+	}
+}
+func testAssignMetadata(t *testing.T, s assign.Strategy) {
+	url := "http://testAssignMetadata.test"
+	meta := storage.Metadata{
+		Label: "label",
+		Note:  "note",
+		Tags:  []string{"tag"},
+	}
+
+	assigned, err := s.Assign(ctx, url, meta)
+	assert.NoError(t, err)
+	assert.Equal(t, meta, assigned.Metadata)
+	assert.False(t, assigned.CreatedAt.IsZero())
+	assert.Equal(t, assigned.CreatedAt, assigned.UpdatedAt)
+
+	// metadata of existing assignment is kept
+	got, err := s.Assign(ctx, url, storage.Metadata{Label: "another"})
+	assert.NoError(t, err)
+	assert.Equal(t, assigned.Value, got.Value)
+	assert.Equal(t, meta, got.Metadata)
+
+	unassigned, err := s.Unassign(ctx, url)
+	assert.NoError(t, err)
+	assert.Equal(t, assigned.Value, unassigned.Value)
+	assert.Equal(t, meta, unassigned.Metadata)
+}
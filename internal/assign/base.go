@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/kaz/private-email-relay/internal/router"
@@ -15,6 +16,8 @@ type (
 	baseStrategy struct {
 		emailDomain   string
 		recipientAddr string
+		// stored with records, which are unassigned after as many deliveries if positive
+		maxDeliveries int
 
 		store storage.Storage
 		route router.Router
@@ -23,6 +26,18 @@ type (
 	producer func() (string, error)
 )
 
+const (
+	routeAttempts      = 3
+	routeRetryInterval = 100 * time.Millisecond
+
+	// how many addresses are tried until one of them is not taken
+	addressAttempts = 5
+)
+
+var (
+	ErrorAddressExhausted = fmt.Errorf("no available address")
+)
+
 func newBaseStrategy(store storage.Storage, route router.Router) (*baseStrategy, error) {
 	strategy := &baseStrategy{
 		store: store,
@@ -42,63 +57,255 @@ func newBaseStrategy(store storage.Storage, route router.Router) (*baseStrategy,
 	return strategy, nil
 }
 
-func (s *baseStrategy) addressProducerFactory(prefix string, randLen int) producer {
+func (s *baseStrategy) addressProducerFactory(prefix string, randLen int, charset []byte) producer {
 	return func() (string, error) {
-		return fmt.Sprintf("%s%s@%s", prefix, randomString(randLen), s.emailDomain), nil
+		random, err := randomString(charset, randLen)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%s%s@%s", prefix, random, s.emailDomain), nil
+	}
+}
+
+// wordsProducerFactory produces human-readable addresses such as "brave.otter.42@domain".
+func (s *baseStrategy) wordsProducerFactory(prefix string, count int, separator string, digits int) producer {
+	return func() (string, error) {
+		words, err := randomWords(count, separator, digits)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%s%s@%s", prefix, words, s.emailDomain), nil
+	}
+}
+
+// retryRoute calls operation until it succeeds or reports the error is not worth retrying.
+func (s *baseStrategy) retryRoute(ctx context.Context, operation func(attempt int) (retry bool, err error)) error {
+	var err error
+	for attempt := 0; attempt < routeAttempts; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return fmt.Errorf("gave up retrying: %v: %w", err, ctx.Err())
+			case <-time.After(time.Duration(attempt) * routeRetryInterval):
+			}
+		}
+
+		var retry bool
+		if retry, err = operation(attempt); !retry {
+			return err
+		}
 	}
+	return err
+}
+
+// localPart returns local part of addr if it is on MG_DOMAIN.
+func (s *baseStrategy) localPart(addr string) (string, bool) {
+	i := strings.LastIndex(addr, "@")
+	if i < 0 || !strings.EqualFold(addr[i+1:], s.emailDomain) {
+		return "", false
+	}
+	return addr[:i], true
+}
+
+func (s *baseStrategy) recipient() string {
+	return s.recipientAddr
+}
+
+// recipientOf returns the recipient of the strategy which owns key, or RECIPIENT if none of them does.
+func (s *baseStrategy) recipientOf(strategies []Strategy, key string) string {
+	for _, strategy := range strategies {
+		if _, ok := strategy.ParseKey(key); !ok {
+			continue
+		}
+		if r, ok := strategy.(interface{ recipient() string }); ok {
+			return r.recipient()
+		}
+	}
+	return s.recipientAddr
+}
+
+func (s *baseStrategy) setRoute(ctx context.Context, addr, to string) error {
+	return s.retryRoute(ctx, func(attempt int) (bool, error) {
+		err := s.route.Set(ctx, addr, to)
+		if errors.Is(err, router.ErrorDuplicated) {
+			// a route found on retry is the one created by the previous attempt whose response was lost,
+			// but on the first attempt, it is owned by someone else and must not be taken over.
+			if attempt > 0 {
+				return false, nil
+			}
+			return false, err
+		}
+		return err != nil, err
+	})
+}
+
+// ensureRoute creates the route unless it already exists, whoever created it.
+func (s *baseStrategy) ensureRoute(ctx context.Context, addr, to string) error {
+	return s.retryRoute(ctx, func(attempt int) (bool, error) {
+		err := s.route.Set(ctx, addr, to)
+		if errors.Is(err, router.ErrorDuplicated) {
+			return false, nil
+		}
+		return err != nil, err
+	})
+}
+func (s *baseStrategy) unsetRoute(ctx context.Context, addr string) error {
+	return s.retryRoute(ctx, func(attempt int) (bool, error) {
+		err := s.route.Unset(ctx, addr)
+		if errors.Is(err, router.ErrorUndefined) {
+			// route is already removed by the previous attempt or by hand
+			return false, nil
+		}
+		return err != nil, err
+	})
 }
 
-func (s *baseStrategy) assignByKey(ctx context.Context, keyProd producer, addrProd producer, expires time.Time) (string, error) {
+func (s *baseStrategy) assignByKey(ctx context.Context, keyProd producer, addrProd producer, expires time.Time, meta storage.Metadata) (*storage.Record, error) {
 	key, err := keyProd()
 	if err != nil {
-		return "", fmt.Errorf("failed to produce key: %w", err)
+		return nil, fmt.Errorf("failed to produce key: %w", err)
 	}
 
-	val, err := s.store.Get(ctx, key)
-	if err != nil && !errors.Is(err, storage.ErrorUndefinedKey) {
-		return "", fmt.Errorf("failed to get value from storage: %w", err)
-	}
-	if val != "" {
-		return val, nil
+	record, err := s.store.Get(ctx, key)
+	if err == nil {
+		return record, nil
+	} else if !errors.Is(err, storage.ErrorUndefinedKey) {
+		return nil, fmt.Errorf("failed to get value from storage: %w", err)
 	}
 
-	addr, err := addrProd()
+	for attempt := 0; attempt < addressAttempts; attempt++ {
+		addr, err := addrProd()
+		if err != nil {
+			return nil, fmt.Errorf("failed to produce address: %w", err)
+		}
+
+		now := time.Now()
+		record = &storage.Record{
+			Key:           key,
+			Value:         addr,
+			Expires:       expires,
+			MaxDeliveries: s.maxDeliveries,
+			Metadata:      meta,
+			CreatedAt:     now,
+			UpdatedAt:     now,
+		}
+
+		if err := s.store.Set(ctx, record); err != nil {
+			if errors.Is(err, storage.ErrorDuplicatedValue) {
+				continue
+			}
+			// the site is assigned by a concurrent Assign since looked up
+			if errors.Is(err, storage.ErrorDuplicatedKey) {
+				record, err := s.store.Get(ctx, key)
+				if err != nil {
+					return nil, fmt.Errorf("failed to get value assigned concurrently from storage: %w", err)
+				}
+				return record, nil
+			}
+			return nil, fmt.Errorf("failed to write to storage: %w", err)
+		}
+		if err := s.setRoute(ctx, addr, s.recipientAddr); err != nil {
+			if _, rollbackErr := s.store.UnsetByKey(ctx, key); rollbackErr != nil {
+				return nil, fmt.Errorf("failed to create route: %v, and failed to rollback storage: %w", err, rollbackErr)
+			}
+			// addresses routed by hand or by other deployments sharing the domain are not ours.
+			// they are not checked beforehand, since routers may list every route to find one.
+			if errors.Is(err, router.ErrorDuplicated) {
+				continue
+			}
+			return nil, fmt.Errorf("failed to create route: %w", err)
+		}
+
+		return record, nil
+	}
+	return nil, fmt.Errorf("%w: tried %d addresses: key=%v", ErrorAddressExhausted, addressAttempts, key)
+}
+func (s *baseStrategy) lookupByKey(ctx context.Context, keyProd producer) (*storage.Record, error) {
+	key, err := keyProd()
 	if err != nil {
-		return "", fmt.Errorf("failed to produce address: %w", err)
+		return nil, fmt.Errorf("failed to produce key: %w", err)
 	}
 
-	if err := s.store.Set(ctx, key, addr, expires); err != nil {
-		return "", fmt.Errorf("failed to write to storage: %w", err)
+	record, err := s.store.Get(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get value from storage: %w", err)
 	}
-	if err := s.route.Set(ctx, addr, s.recipientAddr); err != nil {
-		return "", fmt.Errorf("failed to create route: %w", err)
+	return record, nil
+}
+func (s *baseStrategy) updateExpiryByKey(ctx context.Context, keyProd producer, expires time.Time) (*storage.Record, error) {
+	record, err := s.lookupByKey(ctx, keyProd)
+	if err != nil {
+		return nil, err
 	}
+	return s.updateExpires(ctx, record.Value, expires)
+}
 
-	return addr, nil
+// updateExpiryByAddr updates expiry of addr only if owns reports its key is of the strategy,
+// not to make addresses of other strategies or accounts permanent.
+// returns storage.ErrorUndefinedValue
+func (s *baseStrategy) updateExpiryByAddr(ctx context.Context, addr string, owns func(key string) bool, expires time.Time) (*storage.Record, error) {
+	record, err := s.store.GetByValue(ctx, addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get value from storage: %w", err)
+	}
+	if !owns(record.Key) {
+		return nil, fmt.Errorf("%w: assigned by another strategy or account: value=%v", storage.ErrorUndefinedValue, addr)
+	}
+	return s.updateExpires(ctx, addr, expires)
+}
+func (s *baseStrategy) updateExpires(ctx context.Context, addr string, expires time.Time) (*storage.Record, error) {
+	record, err := s.store.UpdateExpires(ctx, addr, expires)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update storage: %w", err)
+	}
+	return record, nil
 }
-func (s *baseStrategy) unassignByKey(ctx context.Context, keyProd producer) error {
+func (s *baseStrategy) unassignByKey(ctx context.Context, keyProd producer) (*storage.Record, error) {
 	key, err := keyProd()
 	if err != nil {
-		return fmt.Errorf("failed to produce key: %w", err)
+		return nil, fmt.Errorf("failed to produce key: %w", err)
 	}
 
-	addr, err := s.store.UnsetByKey(ctx, key)
+	record, err := s.store.UnsetByKey(ctx, key)
 	if err != nil {
-		return fmt.Errorf("failed to determine address: %w", err)
+		return nil, fmt.Errorf("failed to determine address: %w", err)
 	}
-
-	if err := s.route.Unset(ctx, addr); err != nil {
-		return fmt.Errorf("failed to remove route: %w", err)
+	return s.unsetRouteOrRollback(ctx, record)
+}
+func (s *baseStrategy) unassignByAddr(ctx context.Context, addr string) (*storage.Record, error) {
+	record, err := s.store.UnsetByValue(ctx, addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete from storage: %w", err)
 	}
-	return nil
+	return s.unsetRouteOrRollback(ctx, record)
 }
-func (s *baseStrategy) unassignByAddr(ctx context.Context, addr string) error {
-	if _, err := s.store.UnsetByValue(ctx, addr); err != nil {
-		return fmt.Errorf("failed to delete from storage: %w", err)
+
+// countDelivery counts a mail delivered to addr, and unassigns it if it reached the limit of its record.
+// returns storage.ErrorUndefinedValue
+func (s *baseStrategy) countDelivery(ctx context.Context, addr string) (*storage.Record, bool, error) {
+	record, err := s.store.CountDelivery(ctx, addr)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to count delivery: %w", err)
+	}
+	if record.MaxDeliveries <= 0 || record.Deliveries < record.MaxDeliveries {
+		return record, false, nil
 	}
 
-	if err := s.route.Unset(ctx, addr); err != nil {
-		return fmt.Errorf("failed to remove route: %w", err)
+	if _, err := s.unassignByAddr(ctx, addr); err != nil {
+		// unassigned by another delivery at the same time
+		if errors.Is(err, storage.ErrorUndefinedValue) {
+			return record, true, nil
+		}
+		return nil, false, fmt.Errorf("failed to unassign address reached the limit: %w", err)
+	}
+	return record, true, nil
+}
+func (s *baseStrategy) unsetRouteOrRollback(ctx context.Context, record *storage.Record) (*storage.Record, error) {
+	if err := s.unsetRoute(ctx, record.Value); err != nil {
+		if rollbackErr := s.store.Set(ctx, record); rollbackErr != nil {
+			return nil, fmt.Errorf("failed to remove route: %v, and failed to rollback storage: %w", err, rollbackErr)
+		}
+		return nil, fmt.Errorf("failed to remove route: %w", err)
 	}
-	return nil
+	return record, nil
 }
@@ -0,0 +1,246 @@
+package assign_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/kaz/private-email-relay/internal/assign"
+	"github.com/kaz/private-email-relay/internal/router"
+	"github.com/kaz/private-email-relay/internal/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	errorInjected = fmt.Errorf("injected")
+)
+
+func newFaultyStrategy(t *testing.T) (assign.Strategy, storage.Storage, *router.MockRouter) {
+	store := storage.NewMemoryStorage()
+	route := router.NewMockRouter().(*router.MockRouter)
+
+	s, err := assign.NewDefaultStrategy(store, route)
+	if err != nil {
+		t.Skipf("skip default: %v", err)
+	}
+	return s, store, route
+}
+
+func TestAssignRollback(t *testing.T) {
+	s, store, route := newFaultyStrategy(t)
+	url := "http://testAssignRollback.test"
+
+	for i := 0; i < 3; i++ {
+		route.InjectFault(errorInjected, false)
+	}
+
+	_, err := s.Assign(ctx, url, storage.Metadata{})
+	assert.Error(t, err)
+	assert.True(t, errors.Is(err, errorInjected))
+
+	records, _, err := store.List(ctx, "", 10)
+	assert.NoError(t, err)
+	assert.Empty(t, records)
+
+	_, err = s.Lookup(ctx, url)
+	assert.True(t, errors.Is(err, storage.ErrorUndefinedKey))
+}
+
+func TestAssignRetry(t *testing.T) {
+	s, _, route := newFaultyStrategy(t)
+	url := "http://testAssignRetry.test"
+
+	route.InjectFault(errorInjected, false)
+
+	assigned, err := s.Assign(ctx, url, storage.Metadata{})
+	assert.NoError(t, err)
+
+	err = route.Set(ctx, assigned.Value, "recipient@test.test")
+	assert.True(t, errors.Is(err, router.ErrorDuplicated))
+}
+
+func TestAssignRetryLostResponse(t *testing.T) {
+	s, _, route := newFaultyStrategy(t)
+	url := "http://testAssignRetryLostResponse.test"
+
+	route.InjectFault(errorInjected, true)
+
+	assigned, err := s.Assign(ctx, url, storage.Metadata{})
+	assert.NoError(t, err)
+
+	got, err := s.Lookup(ctx, url)
+	assert.NoError(t, err)
+	assert.Equal(t, assigned.Value, got.Value)
+
+	_, err = s.Unassign(ctx, url)
+	assert.NoError(t, err)
+}
+
+func TestUnassignRollback(t *testing.T) {
+	s, _, route := newFaultyStrategy(t)
+	url := "http://testUnassignRollback.test"
+
+	assigned, err := s.Assign(ctx, url, storage.Metadata{Label: "label"})
+	assert.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		route.InjectFault(errorInjected, false)
+	}
+
+	_, err = s.Unassign(ctx, url)
+	assert.Error(t, err)
+	assert.True(t, errors.Is(err, errorInjected))
+
+	got, err := s.Lookup(ctx, url)
+	assert.NoError(t, err)
+	assert.Equal(t, assigned.Value, got.Value)
+	assert.Equal(t, assigned.Metadata, got.Metadata)
+
+	for i := 0; i < 3; i++ {
+		route.InjectFault(errorInjected, false)
+	}
+
+	_, err = s.UnassignByAddr(ctx, assigned.Value)
+	assert.Error(t, err)
+
+	got, err = s.Lookup(ctx, url)
+	assert.NoError(t, err)
+	assert.Equal(t, assigned.Value, got.Value)
+}
+
+func TestUnassignRouteUndefined(t *testing.T) {
+	s, _, route := newFaultyStrategy(t)
+	url := "http://testUnassignRouteUndefined.test"
+
+	assigned, err := s.Assign(ctx, url, storage.Metadata{})
+	assert.NoError(t, err)
+
+	// route is removed by hand
+	err = route.Unset(ctx, assigned.Value)
+	assert.NoError(t, err)
+
+	_, err = s.Unassign(ctx, url)
+	assert.NoError(t, err)
+
+	_, err = s.Lookup(ctx, url)
+	assert.True(t, errors.Is(err, storage.ErrorUndefinedKey))
+}
+
+func TestUnassignRetryLostResponse(t *testing.T) {
+	s, _, route := newFaultyStrategy(t)
+	url := "http://testUnassignRetryLostResponse.test"
+
+	_, err := s.Assign(ctx, url, storage.Metadata{})
+	assert.NoError(t, err)
+
+	route.InjectFault(errorInjected, true)
+
+	_, err = s.Unassign(ctx, url)
+	assert.NoError(t, err)
+
+	_, err = s.Lookup(ctx, url)
+	assert.True(t, errors.Is(err, storage.ErrorUndefinedKey))
+}
+
+func TestAssignCollision(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	route := router.NewMockRouter()
+
+	// only "a" and "b" can be produced
+	s, err := assign.NewConfigurableStrategy(store, route, assign.StrategyOptions{Length: 1, Charset: "ab"})
+	if err != nil {
+		t.Skipf("skip configurable: %v", err)
+	}
+
+	err = route.Set(ctx, address("a"), "someone@test.test")
+	assert.NoError(t, err)
+
+	// "a" is routed by someone else, so it is never assigned
+	record, err := s.Assign(ctx, "http://testAssignCollision-0.test", storage.Metadata{})
+	if err != nil {
+		assert.True(t, errors.Is(err, assign.ErrorAddressExhausted))
+		record, err = s.Assign(ctx, "http://testAssignCollision-0.test", storage.Metadata{})
+		if err != nil {
+			t.Skipf("skip unlucky collision: %v", err)
+		}
+	}
+	assert.Equal(t, address("b"), record.Value)
+
+	// "b" is stored, and "a" is routed
+	_, err = s.Assign(ctx, "http://testAssignCollision-1.test", storage.Metadata{})
+	assert.True(t, errors.Is(err, assign.ErrorAddressExhausted))
+
+	_, err = s.Lookup(ctx, "http://testAssignCollision-1.test")
+	assert.True(t, errors.Is(err, storage.ErrorUndefinedKey))
+}
+
+// racingStorage calls hook once after the first Get
+type racingStorage struct {
+	storage.Storage
+	hook func()
+	once sync.Once
+}
+
+func (s *racingStorage) Get(ctx context.Context, key string) (*storage.Record, error) {
+	defer s.once.Do(s.hook)
+	return s.Storage.Get(ctx, key)
+}
+
+func TestAssignRace(t *testing.T) {
+	inner := storage.NewMemoryStorage()
+	route := router.NewMockRouter()
+	url := "http://testAssignRace.test"
+
+	winner, err := assign.NewDefaultStrategy(inner, route)
+	if err != nil {
+		t.Skipf("skip default: %v", err)
+	}
+
+	// the other Assign for the same site wins, right after it is looked up
+	var won *storage.Record
+	store := &racingStorage{Storage: inner, hook: func() {
+		won, err = winner.Assign(ctx, url, storage.Metadata{})
+		assert.NoError(t, err)
+	}}
+	loser, err := assign.NewDefaultStrategy(store, route)
+	if err != nil {
+		t.Skipf("skip default: %v", err)
+	}
+
+	lost, err := loser.Assign(ctx, url, storage.Metadata{})
+	assert.NoError(t, err)
+	assert.Equal(t, won.Value, lost.Value)
+
+	records, _, err := inner.List(ctx, "", 10)
+	assert.NoError(t, err)
+	assert.Len(t, records, 1)
+}
+
+// listingRouter counts calls which may list every route
+type listingRouter struct {
+	router.Router
+	listings int
+}
+
+func (r *listingRouter) Set(ctx context.Context, from, to string) error {
+	r.listings++
+	return r.Router.Set(ctx, from, to)
+}
+func (r *listingRouter) Exists(ctx context.Context, from string) (bool, error) {
+	r.listings++
+	return r.Router.Exists(ctx, from)
+}
+
+func TestAssignListsRoutesOnce(t *testing.T) {
+	route := &listingRouter{Router: router.NewMockRouter()}
+	s, err := assign.NewDefaultStrategy(storage.NewMemoryStorage(), route)
+	if err != nil {
+		t.Skipf("skip default: %v", err)
+	}
+
+	_, err = s.Assign(ctx, "http://testAssignListsRoutesOnce.test", storage.Metadata{})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, route.listings)
+}
@@ -0,0 +1,45 @@
+package assign
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/kaz/private-email-relay/internal/router"
+	"github.com/kaz/private-email-relay/internal/storage"
+)
+
+type (
+	// BurnerStrategy assigns addresses which are unassigned after receiving a few mails, such as signup confirmations.
+	// Deliveries are counted by CountDelivery, and addresses expire anyway in case mails never arrive.
+	BurnerStrategy struct {
+		*ConfigurableStrategy
+	}
+)
+
+func burnerStrategyOptions() StrategyOptions {
+	return StrategyOptions{
+		Namespace:     "burner",
+		Prefix:        "b-",
+		Entropy:       defaultEntropy,
+		Separator:     defaultSeparator,
+		Digits:        defaultDigits,
+		Expiry:        3 * 24 * time.Hour,
+		MaxDeliveries: 1,
+	}
+}
+
+func NewBurnerStrategy(store storage.Storage, route router.Router, maxDeliveries int) (Strategy, error) {
+	opts := burnerStrategyOptions()
+	opts.MaxDeliveries = maxDeliveries
+	return newBurnerStrategy(store, route, opts)
+}
+func newBurnerStrategy(store storage.Storage, route router.Router, opts StrategyOptions) (Strategy, error) {
+	if opts.MaxDeliveries <= 0 {
+		return nil, fmt.Errorf("max deliveries of burner must be positive: %d", opts.MaxDeliveries)
+	}
+	configurable, err := NewConfigurableStrategy(store, route, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize configurable strategy: %w", err)
+	}
+	return &BurnerStrategy{configurable}, nil
+}
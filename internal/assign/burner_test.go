@@ -0,0 +1,112 @@
+package assign_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/kaz/private-email-relay/internal/assign"
+	"github.com/kaz/private-email-relay/internal/router"
+	"github.com/kaz/private-email-relay/internal/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBurner(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	route := router.NewMockRouter()
+
+	s, err := assign.NewBurnerStrategy(store, route, 2)
+	if err != nil {
+		t.Skipf("skip burner: %v", err)
+	}
+	counter := s.(assign.DeliveryCounter)
+
+	url := "https://testBurner.test/signup"
+	record, err := s.Assign(ctx, url, storage.Metadata{})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, record.MaxDeliveries)
+	assert.Regexp(t, `^b-[a-z]+@`, record.Value)
+
+	counted, unassigned, err := counter.CountDelivery(ctx, record.Value)
+	assert.NoError(t, err)
+	assert.False(t, unassigned)
+	assert.Equal(t, 1, counted.Deliveries)
+
+	routed, err := route.Exists(ctx, record.Value)
+	assert.NoError(t, err)
+	assert.True(t, routed)
+
+	counted, unassigned, err = counter.CountDelivery(ctx, record.Value)
+	assert.NoError(t, err)
+	assert.True(t, unassigned)
+	assert.Equal(t, 2, counted.Deliveries)
+
+	_, err = s.Lookup(ctx, url)
+	assert.True(t, errors.Is(err, storage.ErrorUndefinedKey))
+	routed, err = route.Exists(ctx, record.Value)
+	assert.NoError(t, err)
+	assert.False(t, routed)
+
+	_, _, err = counter.CountDelivery(ctx, record.Value)
+	assert.True(t, errors.Is(err, storage.ErrorUndefinedValue))
+
+	// a new address is assigned after the previous one is burned
+	again, err := s.Assign(ctx, url, storage.Metadata{})
+	assert.NoError(t, err)
+	assert.NotEqual(t, record.Value, again.Value)
+}
+
+func TestBurnerAlias(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	route := router.NewMockRouter()
+
+	s, err := assign.NewBurnerStrategy(store, route, 1)
+	if err != nil {
+		t.Skipf("skip burner: %v", err)
+	}
+
+	record, err := s.(assign.AliasAssigner).AssignAlias(ctx, "https://testBurnerAlias.test", "testburneralias", storage.Metadata{})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, record.MaxDeliveries)
+
+	_, unassigned, err := s.(assign.DeliveryCounter).CountDelivery(ctx, record.Value)
+	assert.NoError(t, err)
+	assert.True(t, unassigned)
+}
+
+func TestCountDeliveryUnlimited(t *testing.T) {
+	s := implements["default"]
+	if s == nil {
+		t.Skip("skip default")
+	}
+
+	record, err := s.Assign(ctx, "https://testCountDeliveryUnlimited.test", storage.Metadata{})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, record.MaxDeliveries)
+
+	for i := 1; i <= 3; i++ {
+		counted, unassigned, err := s.(assign.DeliveryCounter).CountDelivery(ctx, record.Value)
+		assert.NoError(t, err)
+		assert.False(t, unassigned)
+		assert.Equal(t, i, counted.Deliveries)
+	}
+}
+
+func TestNewBurner(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	route := router.NewMockRouter()
+
+	s, err := assign.New("burner", store, route, assign.Params{"max_deliveries": "3"})
+	if err != nil {
+		t.Skipf("skip burner: %v", err)
+	}
+	assert.Equal(t, "burner", s.(interface{ Namespace() string }).Namespace())
+
+	record, err := s.Assign(ctx, "https://testNewBurner.test", storage.Metadata{})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, record.MaxDeliveries)
+
+	_, err = assign.New("burner", store, route, assign.Params{"max_deliveries": "0"})
+	assert.Error(t, err)
+	_, err = assign.New("custom", store, route, assign.Params{"namespace": "testNewBurner", "max_deliveries": "-1"})
+	assert.Error(t, err)
+}
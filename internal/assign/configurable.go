@@ -0,0 +1,330 @@
+package assign
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/kaz/private-email-relay/internal/router"
+	"github.com/kaz/private-email-relay/internal/storage"
+)
+
+type (
+	// ConfigurableStrategy assigns addresses as configured by StrategyOptions.
+	// DefaultStrategy and TemporaryStrategy are presets of this.
+	ConfigurableStrategy struct {
+		*baseStrategy
+
+		namespace      string
+		deriveKey      keyDeriver
+		overrides      keyOverrides
+		produceAddress producer
+		matchLocal     localMatcher
+		blocked        map[string]bool
+		deadline       deadline
+
+		// set by ForAccount
+		account string
+	}
+
+	StrategyOptions struct {
+		// prepended to keys as "namespace#", which tells strategies apart. keys have no prefix if empty.
+		Namespace string
+		// "domain" (effective domain, by default), "host" (full hostname) or "path" (hostname and first path segment)
+		Key string
+		// Key of specific hosts, such as "*.myshopify.com": "host" (see parseKeyOverrides)
+		Overrides map[string]string
+		// local part of addresses is prefix followed by random characters ("random", by default) or words ("words")
+		Prefix string
+		Format string
+		// for "random" format, of which Length is the minimum if Entropy needs more characters
+		Length  int
+		Charset string
+		// minimum bits of randomness in addresses of "random" format, which lengthens them as needed
+		Entropy int
+		// for "words" format, such as "brave.otter.42" of 2 words, "." separator and 2 digits
+		Words     int
+		Separator string
+		Digits    int
+		// addresses never expire if zero
+		Expiry time.Duration
+		// where mails are forwarded, instead of RECIPIENT
+		Recipient string
+		// words not allowed in aliases chosen by user, in addition to the built-in blocklist
+		Blocklist []string
+		// addresses are unassigned after receiving this many mails, and never if zero
+		MaxDeliveries int
+	}
+
+	keyDeriver func(url string) (string, error)
+	deadline   func() time.Time
+	// reports whether local part of an address is in the format of a strategy
+	localMatcher func(local string) bool
+)
+
+const (
+	defaultCharset = "abcdefghijklmnopqrstuvwxyz"
+	// 7 characters of defaultCharset
+	defaultEntropy = 32
+
+	defaultWords     = 2
+	defaultSeparator = "."
+	defaultDigits    = 2
+)
+
+var (
+	keyDerivers = map[string]keyDeriver{
+		"domain": effectiveDomain,
+		"host":   hostname,
+		"path":   hostnameAndPath,
+	}
+)
+
+func NewConfigurableStrategy(store storage.Storage, route router.Router, opts StrategyOptions) (*ConfigurableStrategy, error) {
+	base, err := newBaseStrategy(store, route)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize base strategy: %w", err)
+	}
+	if opts.Recipient != "" {
+		base.recipientAddr = opts.Recipient
+	}
+	if opts.MaxDeliveries < 0 {
+		return nil, fmt.Errorf("max deliveries must not be negative: %d", opts.MaxDeliveries)
+	}
+	base.maxDeliveries = opts.MaxDeliveries
+
+	if strings.Contains(opts.Namespace, "#") {
+		return nil, fmt.Errorf("namespace must not contain '#': %v", opts.Namespace)
+	}
+
+	if opts.Key == "" {
+		opts.Key = "domain"
+	}
+	deriveKey, ok := keyDerivers[opts.Key]
+	if !ok {
+		return nil, fmt.Errorf("unknown key derivation: %v", opts.Key)
+	}
+	overrides, err := parseKeyOverrides(opts.Overrides)
+	if err != nil {
+		return nil, err
+	}
+
+	produceAddress, matchLocal, err := base.addressFormat(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := func() time.Time { return storage.NeverExpire }
+	if opts.Expiry < 0 {
+		return nil, fmt.Errorf("expiry must not be negative: %v", opts.Expiry)
+	} else if opts.Expiry > 0 {
+		deadline = func() time.Time { return time.Now().Add(opts.Expiry) }
+	}
+
+	return &ConfigurableStrategy{
+		baseStrategy:   base,
+		namespace:      opts.Namespace,
+		deriveKey:      deriveKey,
+		overrides:      overrides,
+		produceAddress: produceAddress,
+		matchLocal:     matchLocal,
+		blocked:        wordSet(opts.Blocklist),
+		deadline:       deadline,
+	}, nil
+}
+
+// addressFormat returns the producer of addresses in the format of opts, and the matcher of them.
+func (s *baseStrategy) addressFormat(opts StrategyOptions) (producer, localMatcher, error) {
+	switch opts.Format {
+	case "", "random":
+		if opts.Charset == "" {
+			opts.Charset = defaultCharset
+		}
+		if err := checkCharset(opts.Charset); err != nil {
+			return nil, nil, err
+		}
+		if opts.Entropy < 0 {
+			return nil, nil, fmt.Errorf("entropy must not be negative: %d", opts.Entropy)
+		}
+		if length := lengthForEntropy([]byte(opts.Charset), opts.Entropy); opts.Length < length {
+			opts.Length = length
+		}
+		if opts.Length <= 0 {
+			return nil, nil, fmt.Errorf("length must be positive: %d", opts.Length)
+		}
+		return s.addressProducerFactory(opts.Prefix, opts.Length, []byte(opts.Charset)), randomMatcher(opts.Prefix, opts.Length, opts.Charset), nil
+
+	case "words":
+		if opts.Words == 0 {
+			opts.Words = defaultWords
+		}
+		if err := checkWords(opts.Words, opts.Separator, opts.Digits); err != nil {
+			return nil, nil, err
+		}
+		return s.wordsProducerFactory(opts.Prefix, opts.Words, opts.Separator, opts.Digits), wordsMatcher(opts.Prefix, opts.Words, opts.Separator, opts.Digits), nil
+
+	default:
+		return nil, nil, fmt.Errorf("unknown address format: %v", opts.Format)
+	}
+}
+
+func randomMatcher(prefix string, length int, charset string) localMatcher {
+	return func(local string) bool {
+		if !strings.HasPrefix(local, prefix) || len(local) != len(prefix)+length {
+			return false
+		}
+		for _, c := range local[len(prefix):] {
+			if !strings.ContainsRune(charset, c) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// checkCharset rejects charsets which make addresses guessable or biased.
+func checkCharset(charset string) error {
+	seen := map[rune]bool{}
+	for _, c := range charset {
+		if seen[c] {
+			return fmt.Errorf("charset must not contain duplicated characters: %q", c)
+		}
+		if c > 0x7f {
+			return fmt.Errorf("charset must consist of ASCII characters: %q", c)
+		}
+		seen[c] = true
+	}
+	if len(seen) < 2 {
+		return fmt.Errorf("charset must contain at least 2 characters: %q", charset)
+	}
+	return nil
+}
+
+func (s *ConfigurableStrategy) Namespace() string {
+	return s.namespace
+}
+
+// ownsAddress reports whether addr is in the address format of this strategy.
+// aliases chosen by user are not, since they can be anything.
+func (s *ConfigurableStrategy) ownsAddress(addr string) bool {
+	local, ok := s.localPart(addr)
+	return ok && s.matchLocal(local)
+}
+
+func (s *ConfigurableStrategy) keyProducerFactory(url string) producer {
+	return func() (string, error) {
+		deriveKey := s.deriveKey
+		if len(s.overrides) > 0 {
+			host, err := hostname(url)
+			if err != nil {
+				return "", fmt.Errorf("error occurred while deriving key: %w", err)
+			}
+			if override, ok := s.overrides.match(host); ok {
+				deriveKey = override
+			}
+		}
+
+		key, err := deriveKey(url)
+		if err != nil {
+			return "", fmt.Errorf("error occurred while deriving key: %w", err)
+		}
+		key = scopeKey(s.account, key)
+		if s.namespace == "" {
+			return key, nil
+		}
+		return fmt.Sprintf("%s#%s", s.namespace, key), nil
+	}
+}
+
+func (s *ConfigurableStrategy) Assign(ctx context.Context, url string, meta storage.Metadata) (*storage.Record, error) {
+	return s.assignByKey(ctx, s.keyProducerFactory(url), s.produceAddress, s.deadline(), meta)
+}
+
+func (s *ConfigurableStrategy) AssignAlias(ctx context.Context, url string, alias string, meta storage.Metadata) (*storage.Record, error) {
+	return s.assignAlias(ctx, s.keyProducerFactory(url), alias, s.blocked, s.deadline(), meta)
+}
+
+func (s *ConfigurableStrategy) Lookup(ctx context.Context, url string) (*storage.Record, error) {
+	return s.lookupByKey(ctx, s.keyProducerFactory(url))
+}
+
+func (s *ConfigurableStrategy) Unassign(ctx context.Context, url string) (*storage.Record, error) {
+	return s.unassignByKey(ctx, s.keyProducerFactory(url))
+}
+
+func (s *ConfigurableStrategy) UnassignByAddr(ctx context.Context, addr string) (*storage.Record, error) {
+	return s.unassignByAddr(ctx, addr)
+}
+
+func (s *ConfigurableStrategy) UpdateExpiry(ctx context.Context, url string, expires time.Time) (*storage.Record, error) {
+	return s.updateExpiryByKey(ctx, s.keyProducerFactory(url), expires)
+}
+
+func (s *ConfigurableStrategy) UpdateExpiryByAddr(ctx context.Context, addr string, expires time.Time) (*storage.Record, error) {
+	return s.updateExpiryByAddr(ctx, addr, s.ownsKey, expires)
+}
+
+func (s *ConfigurableStrategy) CountDelivery(ctx context.Context, addr string) (*storage.Record, bool, error) {
+	return s.countDelivery(ctx, addr)
+}
+
+func (s *ConfigurableStrategy) ParseKey(key string) (string, bool) {
+	if s.namespace == "" {
+		if strings.Contains(key, "#") {
+			return "", false
+		}
+	} else if !strings.HasPrefix(key, s.namespace+"#") {
+		return "", false
+	}
+	_, domain := SplitAccount(strings.TrimPrefix(key, s.namespace+"#"))
+	return domain, true
+}
+
+// ownsKey reports whether key is of this strategy and of the same account, which is empty unless scoped.
+func (s *ConfigurableStrategy) ownsKey(key string) bool {
+	_, ok := s.ParseKey(key)
+	return ok && ParseAccount(key) == s.account
+}
+
+func (s *ConfigurableStrategy) Site(url string) (string, error) {
+	unscoped := *s
+	unscoped.account = ""
+	key, err := unscoped.keyProducerFactory(url)()
+	if err != nil {
+		return "", err
+	}
+	domain, _ := s.ParseKey(key)
+	return domain, nil
+}
+
+func (s *ConfigurableStrategy) ForAccount(account string) (Strategy, error) {
+	account, err := normalizeAccount(account)
+	if err != nil {
+		return nil, err
+	}
+	scoped := *s
+	scoped.account = account
+	return &scoped, nil
+}
+
+// UnassignExpired removes every expired address in storage, including ones assigned by other strategies.
+func (s *ConfigurableStrategy) UnassignExpired(ctx context.Context, until time.Time) (int, error) {
+	deletedAddrs, err := s.store.UnsetExpired(ctx, until)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete from storage: %w", err)
+	}
+
+	// storage entries are already gone, so keep removing the rest of routes even if some of them fail
+	failedAddrs := []string{}
+	for _, addr := range deletedAddrs {
+		if err := s.unsetRoute(ctx, addr); err != nil {
+			failedAddrs = append(failedAddrs, addr)
+		}
+	}
+	if len(failedAddrs) > 0 {
+		return len(deletedAddrs) - len(failedAddrs), fmt.Errorf("failed to remove routes: %v", failedAddrs)
+	}
+
+	return len(deletedAddrs), nil
+}
@@ -0,0 +1,225 @@
+package assign_test
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kaz/private-email-relay/internal/assign"
+	"github.com/kaz/private-email-relay/internal/router"
+	"github.com/kaz/private-email-relay/internal/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+func newConfigurableStrategy(t *testing.T, opts assign.StrategyOptions) (*assign.ConfigurableStrategy, router.Router) {
+	route := router.NewMockRouter()
+	s, err := assign.NewConfigurableStrategy(storage.NewMemoryStorage(), route, opts)
+	if err != nil {
+		t.Skipf("skip configurable: %v", err)
+	}
+	return s, route
+}
+
+func TestConfigurableKey(t *testing.T) {
+	cases := []struct {
+		key  string
+		url  string
+		want string
+	}{
+		{"domain", "https://www.example.co.jp/login", "example.co.jp"},
+		{"host", "https://www.example.co.jp/login", "www.example.co.jp"},
+		{"host", "https://WWW.Example.com:8443/", "www.example.com"},
+		{"path", "https://example.com/shop/items/1?q=1", "example.com/shop"},
+		{"path", "https://example.com", "example.com"},
+		{"host", "https://gist.github.com/kaz", "gist.github.com"},
+		{"host", "https://shop-a.myshopify.com", "shop-a.myshopify.com"},
+		{"path", "https://github.com/kaz/private-email-relay", "github.com/kaz"},
+		{"path", "https://example.com:8080/Shop/", "example.com/Shop"},
+		// IDNs are keyed in punycode, whichever form is given
+		{"host", "https://例え.jp/", "xn--r8jz45g.jp"},
+		{"host", "https://xn--r8jz45g.jp/", "xn--r8jz45g.jp"},
+		{"host", "https://ÉCOLE.fr:443", "xn--cole-9oa.fr"},
+		{"path", "https://bücher.example/shop?a=1", "xn--bcher-kva.example/shop"},
+		{"host", "https://[::1]:8080/", "::1"},
+		{"host", "https://example.com./", "example.com"},
+	}
+
+	for _, c := range cases {
+		s, _ := newConfigurableStrategy(t, assign.StrategyOptions{Namespace: "ns", Key: c.key, Length: 8})
+
+		record, err := s.Assign(ctx, c.url, storage.Metadata{})
+		assert.NoError(t, err)
+		assert.Equal(t, "ns#"+c.want, record.Key)
+
+		domain, ok := s.ParseKey(record.Key)
+		assert.True(t, ok)
+		assert.Equal(t, c.want, domain)
+
+		looked, err := s.Lookup(ctx, c.url)
+		assert.NoError(t, err)
+		assert.Equal(t, record.Value, looked.Value)
+	}
+}
+
+func TestConfigurableParseKey(t *testing.T) {
+	namespaced, _ := newConfigurableStrategy(t, assign.StrategyOptions{Namespace: "ns", Length: 8})
+	plain, _ := newConfigurableStrategy(t, assign.StrategyOptions{Length: 8})
+
+	_, ok := namespaced.ParseKey("example.com")
+	assert.False(t, ok)
+	_, ok = namespaced.ParseKey("other#example.com")
+	assert.False(t, ok)
+
+	domain, ok := plain.ParseKey("example.com")
+	assert.True(t, ok)
+	assert.Equal(t, "example.com", domain)
+	_, ok = plain.ParseKey("ns#example.com")
+	assert.False(t, ok)
+}
+
+func TestConfigurableAddress(t *testing.T) {
+	s, _ := newConfigurableStrategy(t, assign.StrategyOptions{Prefix: "x-", Length: 10, Charset: "01"})
+
+	record, err := s.Assign(ctx, "http://testConfigurableAddress.test", storage.Metadata{})
+	assert.NoError(t, err)
+	assert.Regexp(t, regexp.MustCompile(`^x-[01]{10}@`), record.Value)
+}
+
+func TestConfigurableExpiry(t *testing.T) {
+	s, _ := newConfigurableStrategy(t, assign.StrategyOptions{Length: 8, Expiry: time.Hour})
+	record, err := s.Assign(ctx, "http://testConfigurableExpiry.test", storage.Metadata{})
+	assert.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), record.Expires, time.Minute)
+
+	s, _ = newConfigurableStrategy(t, assign.StrategyOptions{Length: 8})
+	record, err = s.Assign(ctx, "http://testConfigurableExpiry.test", storage.Metadata{})
+	assert.NoError(t, err)
+	assert.True(t, record.Expires.Equal(storage.NeverExpire))
+}
+
+func TestConfigurableRecipient(t *testing.T) {
+	s, route := newConfigurableStrategy(t, assign.StrategyOptions{Length: 8, Recipient: "other@test.test"})
+
+	record, err := s.Assign(ctx, "http://testConfigurableRecipient.test", storage.Metadata{})
+	assert.NoError(t, err)
+
+	routes, err := route.List(ctx)
+	assert.NoError(t, err)
+	assert.Contains(t, routes, &router.Route{From: record.Value, To: "other@test.test"})
+}
+
+func TestConfigurableInvalid(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	route := router.NewMockRouter()
+
+	invalids := []assign.StrategyOptions{
+		{Length: 0},
+		{Length: 8, Namespace: "a#b"},
+		{Length: 8, Key: "query"},
+		{Length: 8, Expiry: -time.Hour},
+		{Length: 8, Entropy: -1},
+		{Length: 8, Charset: "a"},
+		{Length: 8, Charset: "abca"},
+		{Format: "uuid"},
+		{Format: "words", Words: -1},
+		{Format: "words", Separator: "+"},
+		{Format: "words", Digits: -1},
+	}
+	for _, opts := range invalids {
+		_, err := assign.NewConfigurableStrategy(store, route, opts)
+		assert.Error(t, err, "%+v", opts)
+	}
+}
+
+func TestConfigurableEntropy(t *testing.T) {
+	// 32 bits need 7 letters, or 32 binary digits
+	cases := []struct {
+		opts    assign.StrategyOptions
+		pattern string
+	}{
+		{assign.StrategyOptions{Length: 4, Entropy: 32}, `^[a-z]{7}@`},
+		{assign.StrategyOptions{Length: 4, Entropy: 32, Charset: "01"}, `^[01]{32}@`},
+		{assign.StrategyOptions{Length: 10, Entropy: 32}, `^[a-z]{10}@`},
+		{assign.StrategyOptions{Entropy: 1}, `^[a-z]@`},
+	}
+
+	for _, c := range cases {
+		s, _ := newConfigurableStrategy(t, c.opts)
+
+		record, err := s.Assign(ctx, "http://testConfigurableEntropy.test", storage.Metadata{})
+		assert.NoError(t, err)
+		assert.Regexp(t, regexp.MustCompile(c.pattern), record.Value)
+	}
+}
+
+func TestConfigurableWords(t *testing.T) {
+	cases := []struct {
+		opts    assign.StrategyOptions
+		pattern string
+	}{
+		{assign.StrategyOptions{Format: "words", Separator: ".", Digits: 2}, `^[a-z]+\.[a-z]+\.[0-9]{2}$`},
+		{assign.StrategyOptions{Format: "words", Words: 3, Separator: "-"}, `^[a-z]+-[a-z]+-[a-z]+$`},
+		{assign.StrategyOptions{Format: "words", Words: 1, Digits: 4, Prefix: "w-"}, `^w-[a-z]+[0-9]{4}$`},
+	}
+
+	for _, c := range cases {
+		s, _ := newConfigurableStrategy(t, c.opts)
+
+		record, err := s.Assign(ctx, "http://testConfigurableWords.test", storage.Metadata{})
+		assert.NoError(t, err)
+		local := strings.TrimSuffix(record.Value, address(""))
+		assert.NotEqual(t, record.Value, local)
+		assert.Regexp(t, regexp.MustCompile(c.pattern), local)
+	}
+}
+
+func TestConfigurableOverrides(t *testing.T) {
+	s, _ := newConfigurableStrategy(t, assign.StrategyOptions{
+		Namespace: "ns",
+		Length:    8,
+		Overrides: map[string]string{
+			"*.myplatform.test":   "host",
+			"github.com":          "path",
+			"*.github.com":        "domain",
+			"gist.github.com":     "host",
+			"*.ユニコード.jp":          "host",
+			"youtube.com":         "=google",
+			"accounts.google.com": "=google",
+		},
+	})
+
+	cases := []struct {
+		url  string
+		want string
+	}{
+		{"https://shop-a.myplatform.test/cart", "shop-a.myplatform.test"},
+		{"https://a.b.myplatform.test", "a.b.myplatform.test"},
+		// not matched by wildcard
+		{"https://myplatform.test", "myplatform.test"},
+		{"https://github.com/kaz/repo", "github.com/kaz"},
+		{"https://gist.github.com/kaz/1", "gist.github.com"},
+		{"https://api.github.com/users", "github.com"},
+		{"https://shop.xn--tck1be1iye.jp", "shop.xn--tck1be1iye.jp"},
+		{"https://youtube.com/watch", "google"},
+		{"https://accounts.google.com:443/login", "google"},
+		// not overridden
+		{"https://www.example.co.jp", "example.co.jp"},
+	}
+	for _, c := range cases {
+		record, err := s.Assign(ctx, c.url, storage.Metadata{})
+		assert.NoError(t, err, c.url)
+		assert.Equal(t, "ns#"+c.want, record.Key, c.url)
+	}
+
+	invalids := []map[string]string{
+		{"example.com": "query"},
+		{"example.com": "="},
+		{"example.com": "=a#b"},
+		{"exa mple.com": "host"},
+	}
+	for _, overrides := range invalids {
+		_, err := assign.NewConfigurableStrategy(storage.NewMemoryStorage(), router.NewMockRouter(), assign.StrategyOptions{Length: 8, Overrides: overrides})
+		assert.Error(t, err, "%v", overrides)
+	}
+}
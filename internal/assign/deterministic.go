@@ -0,0 +1,237 @@
+package assign
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/kaz/private-email-relay/internal/router"
+	"github.com/kaz/private-email-relay/internal/storage"
+)
+
+type (
+	// DeterministicStrategy derives addresses from HMAC of effective domains,
+	// so that the same site always gets the same address, even after storage is lost.
+	DeterministicStrategy struct {
+		*baseStrategy
+
+		namespace string
+		secret    []byte
+		prefix    string
+		length    int
+
+		// set by ForAccount
+		account string
+	}
+
+	RecoverResult string
+)
+
+const (
+	// base32 characters of 80 bits
+	defaultDeterministicLength = 16
+	minDeterministicSecretSize = 16
+
+	// mapping is restored from the existing route
+	RecoverRestored RecoverResult = "restored"
+	// mapping is already stored
+	RecoverPresent RecoverResult = "present"
+	// the site has never been assigned, since its address is not routed
+	RecoverUnassigned RecoverResult = "unassigned"
+)
+
+var (
+	// lowercase, since local part of addresses may be case-insensitive
+	deterministicEncoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+)
+
+func NewDeterministicStrategy(store storage.Storage, route router.Router, namespace string, secret []byte, prefix string, length int) (*DeterministicStrategy, error) {
+	base, err := newBaseStrategy(store, route)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize base strategy: %w", err)
+	}
+
+	if namespace == "" || strings.Contains(namespace, "#") {
+		return nil, fmt.Errorf("namespace must be non-empty and must not contain '#': %q", namespace)
+	}
+	if len(secret) < minDeterministicSecretSize {
+		return nil, fmt.Errorf("secret must be at least %d bytes, but %d bytes", minDeterministicSecretSize, len(secret))
+	}
+	if length <= 0 || length > deterministicEncoding.EncodedLen(sha256.Size) {
+		return nil, fmt.Errorf("length must be in 1..%d: %d", deterministicEncoding.EncodedLen(sha256.Size), length)
+	}
+
+	return &DeterministicStrategy{
+		baseStrategy: base,
+		namespace:    namespace,
+		secret:       secret,
+		prefix:       prefix,
+		length:       length,
+	}, nil
+}
+
+func (s *DeterministicStrategy) Namespace() string {
+	return s.namespace
+}
+
+// derive returns the storage key and address of url.
+func (s *DeterministicStrategy) derive(url string) (string, string, error) {
+	domain, err := effectiveDomain(url)
+	if err != nil {
+		return "", "", fmt.Errorf("error occurred while deriving key: %w", err)
+	}
+	// the same site must not get another address by case of URL
+	scoped := scopeKey(s.account, strings.ToLower(domain))
+
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(scoped))
+	local := deterministicEncoding.EncodeToString(mac.Sum(nil))[:s.length]
+
+	return fmt.Sprintf("%s#%s", s.namespace, scoped), fmt.Sprintf("%s%s@%s", s.prefix, local, s.emailDomain), nil
+}
+
+func (s *DeterministicStrategy) ownsAddress(addr string) bool {
+	local, ok := s.localPart(addr)
+	if !ok || !strings.HasPrefix(local, s.prefix) || len(local) != len(s.prefix)+s.length {
+		return false
+	}
+	for _, c := range local[len(s.prefix):] {
+		if !('a' <= c && c <= 'z') && !('2' <= c && c <= '7') {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *DeterministicStrategy) keyProducerFactory(url string) producer {
+	return func() (string, error) {
+		key, _, err := s.derive(url)
+		return key, err
+	}
+}
+
+func (s *DeterministicStrategy) Assign(ctx context.Context, url string, meta storage.Metadata) (*storage.Record, error) {
+	key, addr, err := s.derive(url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to produce key: %w", err)
+	}
+
+	record, err := s.store.Get(ctx, key)
+	if err == nil {
+		return record, nil
+	} else if !errors.Is(err, storage.ErrorUndefinedKey) {
+		return nil, fmt.Errorf("failed to get value from storage: %w", err)
+	}
+
+	now := time.Now()
+	record = &storage.Record{
+		Key:       key,
+		Value:     addr,
+		Expires:   storage.NeverExpire,
+		Metadata:  meta,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	// the address cannot be changed on collision, unlike random ones
+	if err := s.store.Set(ctx, record); err != nil {
+		return nil, fmt.Errorf("failed to write to storage: %w", err)
+	}
+	// an existing route is the one created before storage was lost
+	if err := s.ensureRoute(ctx, addr, s.recipientAddr); err != nil {
+		if _, rollbackErr := s.store.UnsetByKey(ctx, key); rollbackErr != nil {
+			return nil, fmt.Errorf("failed to create route: %v, and failed to rollback storage: %w", err, rollbackErr)
+		}
+		return nil, fmt.Errorf("failed to create route: %w", err)
+	}
+
+	return record, nil
+}
+
+func (s *DeterministicStrategy) Lookup(ctx context.Context, url string) (*storage.Record, error) {
+	return s.lookupByKey(ctx, s.keyProducerFactory(url))
+}
+
+func (s *DeterministicStrategy) Unassign(ctx context.Context, url string) (*storage.Record, error) {
+	return s.unassignByKey(ctx, s.keyProducerFactory(url))
+}
+
+func (s *DeterministicStrategy) UnassignByAddr(ctx context.Context, addr string) (*storage.Record, error) {
+	return s.unassignByAddr(ctx, addr)
+}
+
+func (s *DeterministicStrategy) ParseKey(key string) (string, bool) {
+	if !strings.HasPrefix(key, s.namespace+"#") {
+		return "", false
+	}
+	_, domain := SplitAccount(strings.TrimPrefix(key, s.namespace+"#"))
+	return domain, true
+}
+
+func (s *DeterministicStrategy) Site(url string) (string, error) {
+	key, _, err := s.derive(url)
+	if err != nil {
+		return "", err
+	}
+	domain, _ := s.ParseKey(key)
+	return domain, nil
+}
+
+func (s *DeterministicStrategy) ForAccount(account string) (Strategy, error) {
+	account, err := normalizeAccount(account)
+	if err != nil {
+		return nil, err
+	}
+	scoped := *s
+	scoped.account = account
+	return &scoped, nil
+}
+
+// Recover stores the mapping of url again if its address is routed, without creating any route.
+// Metadata is not recoverable, and left empty.
+// returns storage.ErrorDuplicatedKey, storage.ErrorDuplicatedValue if another mapping is stored
+func (s *DeterministicStrategy) Recover(ctx context.Context, url string, dryRun bool) (RecoverResult, error) {
+	key, addr, err := s.derive(url)
+	if err != nil {
+		return "", fmt.Errorf("failed to produce key: %w", err)
+	}
+
+	record, err := s.store.Get(ctx, key)
+	if err == nil {
+		if record.Value != addr {
+			return "", fmt.Errorf("%w: stored address is %v: key=%v", storage.ErrorDuplicatedKey, record.Value, key)
+		}
+		return RecoverPresent, nil
+	} else if !errors.Is(err, storage.ErrorUndefinedKey) {
+		return "", fmt.Errorf("failed to get value from storage: %w", err)
+	}
+
+	routed, err := s.route.Exists(ctx, addr)
+	if err != nil {
+		return "", fmt.Errorf("failed to check route: %w", err)
+	}
+	if !routed {
+		return RecoverUnassigned, nil
+	}
+	if dryRun {
+		return RecoverRestored, nil
+	}
+
+	now := time.Now()
+	record = &storage.Record{
+		Key:       key,
+		Value:     addr,
+		Expires:   storage.NeverExpire,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if err := s.store.Set(ctx, record); err != nil {
+		return "", fmt.Errorf("failed to write to storage: %w", err)
+	}
+	return RecoverRestored, nil
+}
@@ -0,0 +1,115 @@
+package assign_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/kaz/private-email-relay/internal/assign"
+	"github.com/kaz/private-email-relay/internal/router"
+	"github.com/kaz/private-email-relay/internal/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+func newDeterministicStrategy(t *testing.T, store storage.Storage, route router.Router, secret string) *assign.DeterministicStrategy {
+	s, err := assign.NewDeterministicStrategy(store, route, "hmac", []byte(secret), "", 16)
+	if err != nil {
+		t.Skipf("skip deterministic: %v", err)
+	}
+	return s
+}
+
+func TestDeterministicAddress(t *testing.T) {
+	route := router.NewMockRouter()
+	s := newDeterministicStrategy(t, storage.NewMemoryStorage(), route, "testDeterministicSecret")
+	url := "https://www.testDeterministicAddress.test/login"
+
+	assigned, err := s.Assign(ctx, url, storage.Metadata{})
+	assert.NoError(t, err)
+	assert.Regexp(t, `^[a-z2-7]{16}@`, assigned.Value)
+
+	// the same address even after storage is lost
+	again, err := newDeterministicStrategy(t, storage.NewMemoryStorage(), route, "testDeterministicSecret").Assign(ctx, url, storage.Metadata{})
+	assert.NoError(t, err)
+	assert.Equal(t, assigned.Value, again.Value)
+
+	other, err := newDeterministicStrategy(t, storage.NewMemoryStorage(), router.NewMockRouter(), "testAnotherSecretOf").Assign(ctx, url, storage.Metadata{})
+	assert.NoError(t, err)
+	assert.NotEqual(t, assigned.Value, other.Value)
+}
+
+func TestDeterministicUnassign(t *testing.T) {
+	s := newDeterministicStrategy(t, storage.NewMemoryStorage(), router.NewMockRouter(), "testDeterministicSecret")
+	url := "https://testDeterministicUnassign.test"
+
+	assigned, err := s.Assign(ctx, url, storage.Metadata{})
+	assert.NoError(t, err)
+
+	domain, ok := s.ParseKey(assigned.Key)
+	assert.True(t, ok)
+	assert.Equal(t, "testdeterministicunassign.test", domain)
+
+	_, err = s.Unassign(ctx, url)
+	assert.NoError(t, err)
+	_, err = s.Lookup(ctx, url)
+	assert.True(t, errors.Is(err, storage.ErrorUndefinedKey))
+
+	// unlike other strategies, unassigned addresses come back
+	again, err := s.Assign(ctx, url, storage.Metadata{})
+	assert.NoError(t, err)
+	assert.Equal(t, assigned.Value, again.Value)
+}
+
+func TestDeterministicRecover(t *testing.T) {
+	route := router.NewMockRouter()
+	urls := []string{
+		"https://testDeterministicRecover-0.test",
+		"https://testDeterministicRecover-1.test",
+	}
+
+	lost := newDeterministicStrategy(t, storage.NewMemoryStorage(), route, "testDeterministicSecret")
+	assigned, err := lost.Assign(ctx, urls[0], storage.Metadata{})
+	assert.NoError(t, err)
+
+	store := storage.NewMemoryStorage()
+	s := newDeterministicStrategy(t, store, route, "testDeterministicSecret")
+
+	result, err := s.Recover(ctx, urls[0], true)
+	assert.NoError(t, err)
+	assert.Equal(t, assign.RecoverRestored, result)
+	_, err = s.Lookup(ctx, urls[0])
+	assert.True(t, errors.Is(err, storage.ErrorUndefinedKey))
+
+	result, err = s.Recover(ctx, urls[0], false)
+	assert.NoError(t, err)
+	assert.Equal(t, assign.RecoverRestored, result)
+
+	got, err := s.Lookup(ctx, urls[0])
+	assert.NoError(t, err)
+	assert.Equal(t, assigned.Value, got.Value)
+
+	result, err = s.Recover(ctx, urls[0], false)
+	assert.NoError(t, err)
+	assert.Equal(t, assign.RecoverPresent, result)
+
+	result, err = s.Recover(ctx, urls[1], false)
+	assert.NoError(t, err)
+	assert.Equal(t, assign.RecoverUnassigned, result)
+	_, err = s.Lookup(ctx, urls[1])
+	assert.True(t, errors.Is(err, storage.ErrorUndefinedKey))
+}
+
+func TestDeterministicInvalid(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	route := router.NewMockRouter()
+
+	_, err := assign.NewDeterministicStrategy(store, route, "hmac", []byte("short"), "", 16)
+	assert.Error(t, err)
+	_, err = assign.NewDeterministicStrategy(store, route, "", []byte("testDeterministicSecret"), "", 16)
+	assert.Error(t, err)
+	_, err = assign.NewDeterministicStrategy(store, route, "hmac", []byte("testDeterministicSecret"), "", 100)
+	assert.Error(t, err)
+
+	_, err = assign.New("deterministic", store, route, nil)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "secret")
+}
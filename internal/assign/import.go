@@ -0,0 +1,158 @@
+package assign
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/kaz/private-email-relay/internal/router"
+	"github.com/kaz/private-email-relay/internal/storage"
+)
+
+type (
+	Importer struct {
+		*baseStrategy
+
+		// to route imported addresses to recipients of their strategies
+		strategies []Strategy
+	}
+
+	// ConflictPolicy decides what to do with a record whose key or address is already stored
+	ConflictPolicy string
+	ImportResult   string
+)
+
+const (
+	ConflictSkip      ConflictPolicy = "skip"
+	ConflictOverwrite ConflictPolicy = "overwrite"
+	ConflictFail      ConflictPolicy = "fail"
+
+	ImportCreated     ImportResult = "created"
+	ImportOverwritten ImportResult = "overwritten"
+	ImportSkipped     ImportResult = "skipped"
+)
+
+func NewImporter(store storage.Storage, route router.Router, strategies ...Strategy) (*Importer, error) {
+	base, err := newBaseStrategy(store, route)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize base strategy: %w", err)
+	}
+	return &Importer{base, strategies}, nil
+}
+
+func ParseConflictPolicy(s string) (ConflictPolicy, error) {
+	switch policy := ConflictPolicy(s); policy {
+	case ConflictSkip, ConflictOverwrite, ConflictFail:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unknown conflict policy: %v", s)
+	}
+}
+
+// Import stores the record and creates its route unless it exists.
+// returns ErrorInvalidAlias, and storage.ErrorDuplicatedKey, storage.ErrorDuplicatedValue if policy is ConflictFail
+func (i *Importer) Import(ctx context.Context, record *storage.Record, policy ConflictPolicy) (ImportResult, error) {
+	// imported addresses are routed as they are, so that they must be in the syntax of custom aliases.
+	// reserved or blocked words are not checked, since addresses assigned before those rules must be restored.
+	local, ok := i.localPart(record.Value)
+	if !ok {
+		return "", fmt.Errorf("%w: not on %v: %v", ErrorInvalidAlias, i.emailDomain, record.Value)
+	}
+	if err := validateLocalPart(local); err != nil {
+		return "", err
+	}
+
+	recipient := i.recipientOf(i.strategies, record.Key)
+
+	err := i.store.Set(ctx, record)
+	if errors.Is(err, storage.ErrorDuplicatedKey) || errors.Is(err, storage.ErrorDuplicatedValue) {
+		switch policy {
+		case ConflictSkip:
+			// the same record may be imported again to restore its route
+			if existing, getErr := i.store.Get(ctx, record.Key); getErr == nil && existing.Value == record.Value {
+				if err := i.ensureRoute(ctx, record.Value, recipient); err != nil {
+					return "", fmt.Errorf("failed to create route: %w", err)
+				}
+			}
+			return ImportSkipped, nil
+		case ConflictOverwrite:
+			return i.overwrite(ctx, record, recipient)
+		default:
+			return "", err
+		}
+	} else if err != nil {
+		return "", fmt.Errorf("failed to write to storage: %w", err)
+	}
+
+	if err := i.ensureRoute(ctx, record.Value, recipient); err != nil {
+		if _, rollbackErr := i.store.UnsetByKey(ctx, record.Key); rollbackErr != nil {
+			return "", fmt.Errorf("failed to create route: %v, and failed to rollback storage: %w", err, rollbackErr)
+		}
+		return "", fmt.Errorf("failed to create route: %w", err)
+	}
+	return ImportCreated, nil
+}
+
+// overwrite replaces records which conflict with the record.
+// the route is created first and storage is written last, and evicted records are restored on failure,
+// so that no address is left without both of its record and route.
+func (i *Importer) overwrite(ctx context.Context, record *storage.Record, recipient string) (ImportResult, error) {
+	if err := i.ensureRoute(ctx, record.Value, recipient); err != nil {
+		return "", fmt.Errorf("failed to create route: %w", err)
+	}
+
+	evicted, err := i.evict(ctx, record)
+	if err == nil {
+		if err = i.store.Set(ctx, record); err != nil {
+			err = fmt.Errorf("failed to write to storage: %w", err)
+		}
+	}
+	if err != nil {
+		// the route is kept, since it may have existed before, and is reported by Reconciler otherwise
+		if restoreErr := i.restore(ctx, evicted); restoreErr != nil {
+			return "", fmt.Errorf("%v, and failed to restore evicted records: %w", err, restoreErr)
+		}
+		return "", err
+	}
+
+	// route of the overwritten address is no longer used
+	for _, old := range evicted {
+		if old.Value == record.Value {
+			continue
+		}
+		if err := i.unsetRoute(ctx, old.Value); err != nil {
+			return "", fmt.Errorf("imported, but failed to remove route of overwritten address: %w", err)
+		}
+	}
+	return ImportOverwritten, nil
+}
+
+// evict removes records which conflict with the record from storage, and returns them even on failure.
+// their routes are kept, until the record is written in their place.
+func (i *Importer) evict(ctx context.Context, record *storage.Record) ([]*storage.Record, error) {
+	evicted := []*storage.Record{}
+
+	byKey, err := i.store.UnsetByKey(ctx, record.Key)
+	if err == nil {
+		evicted = append(evicted, byKey)
+	} else if !errors.Is(err, storage.ErrorUndefinedKey) {
+		return evicted, fmt.Errorf("failed to delete from storage: %w", err)
+	}
+
+	// route of the address is taken over
+	byValue, err := i.store.UnsetByValue(ctx, record.Value)
+	if err == nil {
+		evicted = append(evicted, byValue)
+	} else if !errors.Is(err, storage.ErrorUndefinedValue) {
+		return evicted, fmt.Errorf("failed to delete from storage: %w", err)
+	}
+	return evicted, nil
+}
+func (i *Importer) restore(ctx context.Context, evicted []*storage.Record) error {
+	for _, record := range evicted {
+		if err := i.store.Set(ctx, record); err != nil {
+			return fmt.Errorf("failed to write to storage: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,199 @@
+package assign_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/kaz/private-email-relay/internal/assign"
+	"github.com/kaz/private-email-relay/internal/router"
+	"github.com/kaz/private-email-relay/internal/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+func newImporter(t *testing.T) (*assign.Importer, storage.Storage, router.Router) {
+	store := storage.NewMemoryStorage()
+	route := router.NewMockRouter()
+
+	i, err := assign.NewImporter(store, route)
+	if err != nil {
+		t.Skipf("skip importer: %v", err)
+	}
+	return i, store, route
+}
+
+func newImportRecord(key, value string) *storage.Record {
+	now := time.Now()
+	return &storage.Record{Key: key, Value: value, Expires: storage.NeverExpire, CreatedAt: now, UpdatedAt: now}
+}
+
+func TestImport(t *testing.T) {
+	i, store, route := newImporter(t)
+	record := newImportRecord("testImport.test", address("testImport"))
+
+	result, err := i.Import(ctx, record, assign.ConflictFail)
+	assert.NoError(t, err)
+	assert.Equal(t, assign.ImportCreated, result)
+
+	got, err := store.Get(ctx, record.Key)
+	assert.NoError(t, err)
+	assert.Equal(t, record.Value, got.Value)
+
+	err = route.Set(ctx, record.Value, "recipient@test.test")
+	assert.True(t, errors.Is(err, router.ErrorDuplicated))
+}
+
+func TestImportConflict(t *testing.T) {
+	i, store, route := newImporter(t)
+
+	existing := newImportRecord("testImportConflict.test", address("testImportConflict-0"))
+	_, err := i.Import(ctx, existing, assign.ConflictFail)
+	assert.NoError(t, err)
+
+	record := newImportRecord("testImportConflict.test", address("testImportConflict-1"))
+
+	_, err = i.Import(ctx, record, assign.ConflictFail)
+	assert.True(t, errors.Is(err, storage.ErrorDuplicatedKey))
+
+	result, err := i.Import(ctx, record, assign.ConflictSkip)
+	assert.NoError(t, err)
+	assert.Equal(t, assign.ImportSkipped, result)
+
+	got, err := store.Get(ctx, record.Key)
+	assert.NoError(t, err)
+	assert.Equal(t, existing.Value, got.Value)
+
+	result, err = i.Import(ctx, record, assign.ConflictOverwrite)
+	assert.NoError(t, err)
+	assert.Equal(t, assign.ImportOverwritten, result)
+
+	got, err = store.Get(ctx, record.Key)
+	assert.NoError(t, err)
+	assert.Equal(t, record.Value, got.Value)
+
+	// route of the overwritten address is removed
+	err = route.Unset(ctx, existing.Value)
+	assert.True(t, errors.Is(err, router.ErrorUndefined))
+	err = route.Unset(ctx, record.Value)
+	assert.NoError(t, err)
+}
+
+func TestImportRestoresRoute(t *testing.T) {
+	i, _, route := newImporter(t)
+	record := newImportRecord("testImportRestoresRoute.test", address("testImportRestoresRoute"))
+
+	_, err := i.Import(ctx, record, assign.ConflictSkip)
+	assert.NoError(t, err)
+	err = route.Unset(ctx, record.Value)
+	assert.NoError(t, err)
+
+	result, err := i.Import(ctx, record, assign.ConflictSkip)
+	assert.NoError(t, err)
+	assert.Equal(t, assign.ImportSkipped, result)
+
+	err = route.Unset(ctx, record.Value)
+	assert.NoError(t, err)
+}
+
+func TestImportInvalid(t *testing.T) {
+	i, store, route := newImporter(t)
+
+	invalids := []string{
+		"testImportInvalid@other.test",
+		"testImportInvalid",
+		address("test.*"),
+		address("test+import"),
+	}
+	for n, addr := range invalids {
+		record := newImportRecord(fmt.Sprintf("testImportInvalid-%d.test", n), addr)
+
+		_, err := i.Import(ctx, record, assign.ConflictOverwrite)
+		assert.True(t, errors.Is(err, assign.ErrorInvalidAlias), addr)
+
+		_, err = store.Get(ctx, record.Key)
+		assert.True(t, errors.Is(err, storage.ErrorUndefinedKey), addr)
+		exists, err := route.Exists(ctx, addr)
+		assert.NoError(t, err)
+		assert.False(t, exists, addr)
+	}
+}
+
+func TestImportReserved(t *testing.T) {
+	i, store, _ := newImporter(t)
+
+	// assigned before reserved or blocked words were rejected
+	record := newImportRecord("testImportReserved.test", address("postmaster"))
+
+	_, err := i.Import(ctx, record, assign.ConflictFail)
+	assert.NoError(t, err)
+
+	got, err := store.Get(ctx, record.Key)
+	assert.NoError(t, err)
+	assert.Equal(t, record.Value, got.Value)
+}
+
+// failingStorage fails the nth Set, counted from one
+type failingStorage struct {
+	storage.Storage
+	failAt int
+	sets   int
+}
+
+func (s *failingStorage) Set(ctx context.Context, record *storage.Record) error {
+	if s.sets++; s.sets == s.failAt {
+		return errorInjected
+	}
+	return s.Storage.Set(ctx, record)
+}
+
+func TestImportOverwriteRollback(t *testing.T) {
+	inner := storage.NewMemoryStorage()
+	route := router.NewMockRouter().(*router.MockRouter)
+
+	// the first Set conflicts, and the second one writes the overwriting record
+	store := &failingStorage{Storage: inner}
+	i, err := assign.NewImporter(store, route)
+	if err != nil {
+		t.Skipf("skip importer: %v", err)
+	}
+
+	existing := newImportRecord("testImportOverwriteRollback.test", address("testImportOverwriteRollback-0"))
+	_, err = i.Import(ctx, existing, assign.ConflictFail)
+	assert.NoError(t, err)
+
+	record := newImportRecord("testImportOverwriteRollback.test", address("testImportOverwriteRollback-1"))
+	assertIntact := func() {
+		got, err := inner.Get(ctx, existing.Key)
+		assert.NoError(t, err)
+		assert.Equal(t, existing.Value, got.Value)
+
+		exists, err := route.Exists(ctx, existing.Value)
+		assert.NoError(t, err)
+		assert.True(t, exists)
+	}
+
+	// route of the record cannot be created
+	for n := 0; n < 3; n++ {
+		route.InjectFault(errorInjected, false)
+	}
+	_, err = i.Import(ctx, record, assign.ConflictOverwrite)
+	assert.True(t, errors.Is(err, errorInjected))
+	assertIntact()
+
+	// the record cannot be written in place of the evicted one
+	store.sets, store.failAt = 0, 2
+	_, err = i.Import(ctx, record, assign.ConflictOverwrite)
+	assert.True(t, errors.Is(err, errorInjected))
+	assertIntact()
+
+	store.failAt = 0
+	result, err := i.Import(ctx, record, assign.ConflictOverwrite)
+	assert.NoError(t, err)
+	assert.Equal(t, assign.ImportOverwritten, result)
+
+	exists, err := route.Exists(ctx, existing.Value)
+	assert.NoError(t, err)
+	assert.False(t, exists)
+}
@@ -0,0 +1,60 @@
+package assign
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+type (
+	// keyOverrides maps hosts to key derivation, such as "*.myshopify.com" to "host".
+	// exact hosts win over wildcards, and longer wildcards win over shorter ones.
+	keyOverrides map[string]keyDeriver
+)
+
+// parseKeyOverrides reads rules of hosts, which are "domain", "host", "path" or "=name".
+// "=name" groups hosts under the fixed key "name", such as google.com and youtube.com.
+func parseKeyOverrides(rules map[string]string) (keyOverrides, error) {
+	overrides := keyOverrides{}
+	for pattern, rule := range rules {
+		wildcard := strings.HasPrefix(pattern, "*.")
+		host, err := idna.Lookup.ToASCII(strings.TrimPrefix(pattern, "*."))
+		if err != nil || host == "" {
+			return nil, fmt.Errorf("invalid host of override: %q", pattern)
+		}
+		if wildcard {
+			host = "*." + host
+		}
+
+		if strings.HasPrefix(rule, "=") {
+			name := strings.TrimPrefix(rule, "=")
+			// '@' is reserved for accounts
+			if name == "" || strings.ContainsAny(name, "#@") {
+				return nil, fmt.Errorf("invalid group of override %v: %q", pattern, rule)
+			}
+			overrides[host] = func(string) (string, error) { return name, nil }
+			continue
+		}
+
+		derive, ok := keyDerivers[rule]
+		if !ok {
+			return nil, fmt.Errorf("unknown key derivation of override %v: %v", pattern, rule)
+		}
+		overrides[host] = derive
+	}
+	return overrides, nil
+}
+
+func (o keyOverrides) match(host string) (keyDeriver, bool) {
+	if derive, ok := o[host]; ok {
+		return derive, true
+	}
+	for suffix := host; strings.Contains(suffix, "."); {
+		suffix = suffix[strings.Index(suffix, ".")+1:]
+		if derive, ok := o["*."+suffix]; ok {
+			return derive, true
+		}
+	}
+	return nil, false
+}
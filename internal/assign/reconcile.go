@@ -0,0 +1,169 @@
+package assign
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/kaz/private-email-relay/internal/router"
+	"github.com/kaz/private-email-relay/internal/storage"
+)
+
+type (
+	Reconciler struct {
+		*baseStrategy
+
+		// to route missing addresses to recipients of their strategies
+		strategies []Strategy
+	}
+
+	ReconcileReport struct {
+		// routed in the address format of a strategy, but not stored
+		OrphanedRoutes []string
+		// routed, but neither stored nor in the address format of any strategy, such as ones created by hand
+		UnknownRoutes []string
+		// stored, but not routed
+		MissingRoutes []string
+		// differences fixed by this run
+		Repaired []string
+	}
+
+	// strategies which tell their addresses from ones created by others
+	addressOwner interface {
+		ownsAddress(addr string) bool
+	}
+)
+
+const (
+	listPageSize = 100
+
+	// characters which make addresses of routes patterns, such as catch-all ".*@domain"
+	patternCharacters = `\+*?()|[]{}^$`
+)
+
+func NewReconciler(store storage.Storage, route router.Router, strategies ...Strategy) (*Reconciler, error) {
+	base, err := newBaseStrategy(store, route)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize base strategy: %w", err)
+	}
+	return &Reconciler{base, strategies}, nil
+}
+
+// storedAddrs returns address -> key
+func (r *Reconciler) storedAddrs(ctx context.Context) (map[string]string, error) {
+	addrs := map[string]string{}
+	for cursor, first := "", true; first || cursor != ""; first = false {
+		records, nextCursor, err := r.store.List(ctx, cursor, listPageSize)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list storage: %w", err)
+		}
+		for _, record := range records {
+			addrs[strings.ToLower(record.Value)] = record.Key
+		}
+		cursor = nextCursor
+	}
+	return addrs, nil
+}
+
+func (r *Reconciler) routedAddrs(ctx context.Context) (map[string]bool, error) {
+	routes, err := r.route.List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list routes: %w", err)
+	}
+
+	addrs := map[string]bool{}
+	for _, route := range routes {
+		// routes for other domains are not managed by us
+		if strings.HasSuffix(strings.ToLower(route.From), "@"+strings.ToLower(r.emailDomain)) {
+			addrs[strings.ToLower(route.From)] = true
+		}
+	}
+	return addrs, nil
+}
+
+func (r *Reconciler) ownsAddress(addr string) bool {
+	for _, strategy := range r.strategies {
+		if owner, ok := strategy.(addressOwner); ok && owner.ownsAddress(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Reconcile finds differences between storage and routes, and fixes them unless dryRun is set.
+// Orphaned routes are removed, and missing routes are created.
+// Unknown routes are removed only if removeUnknown is set, and patterns such as catch-all routes are never removed.
+func (r *Reconciler) Reconcile(ctx context.Context, dryRun bool, removeUnknown bool) (*ReconcileReport, error) {
+	// routes are listed before storage, since Assign stores records before routing them.
+	// otherwise, a route created in the meantime would be taken for an orphan and removed.
+	routed, err := r.routedAddrs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	stored, err := r.storedAddrs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &ReconcileReport{
+		OrphanedRoutes: []string{},
+		UnknownRoutes:  []string{},
+		MissingRoutes:  []string{},
+		Repaired:       []string{},
+	}
+	for addr := range routed {
+		if _, ok := stored[addr]; ok {
+			continue
+		}
+		if r.ownsAddress(addr) {
+			report.OrphanedRoutes = append(report.OrphanedRoutes, addr)
+		} else {
+			report.UnknownRoutes = append(report.UnknownRoutes, addr)
+		}
+	}
+	for addr := range stored {
+		if !routed[addr] {
+			report.MissingRoutes = append(report.MissingRoutes, addr)
+		}
+	}
+
+	if dryRun {
+		return report, nil
+	}
+
+	removed := report.OrphanedRoutes
+	if removeUnknown {
+		for _, addr := range report.UnknownRoutes {
+			if !strings.ContainsAny(addr, patternCharacters) {
+				removed = append(removed, addr)
+			}
+		}
+	}
+
+	failed := []string{}
+	for _, addr := range removed {
+		if err := r.unsetRoute(ctx, addr); err != nil {
+			failed = append(failed, fmt.Sprintf("%v: %v", addr, err))
+			continue
+		}
+		report.Repaired = append(report.Repaired, addr)
+	}
+	for _, addr := range report.MissingRoutes {
+		err := r.setRoute(ctx, addr, r.recipientOf(r.strategies, stored[addr]))
+		// routed by Assign since listed
+		if errors.Is(err, router.ErrorDuplicated) {
+			continue
+		}
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%v: %v", addr, err))
+			continue
+		}
+		report.Repaired = append(report.Repaired, addr)
+	}
+	if len(failed) > 0 {
+		return report, fmt.Errorf("failed to repair some of differences: %v", failed)
+	}
+
+	return report, nil
+}
@@ -0,0 +1,186 @@
+package assign_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/kaz/private-email-relay/internal/assign"
+	"github.com/kaz/private-email-relay/internal/router"
+	"github.com/kaz/private-email-relay/internal/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReconcile(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	route := router.NewMockRouter()
+
+	s, err := assign.NewDefaultStrategy(store, route)
+	if err != nil {
+		t.Skipf("skip default: %v", err)
+	}
+	r, err := assign.NewReconciler(store, route, s)
+	if err != nil {
+		t.Skipf("skip reconciler: %v", err)
+	}
+
+	consistent, err := s.Assign(ctx, "http://testReconcile-consistent.test", storage.Metadata{})
+	assert.NoError(t, err)
+
+	orphaned, err := s.Assign(ctx, "http://testReconcile-orphaned.test", storage.Metadata{})
+	assert.NoError(t, err)
+	_, err = store.UnsetByKey(ctx, orphaned.Key)
+	assert.NoError(t, err)
+
+	missing, err := s.Assign(ctx, "http://testReconcile-missing.test", storage.Metadata{})
+	assert.NoError(t, err)
+	err = route.Unset(ctx, missing.Value)
+	assert.NoError(t, err)
+
+	// not managed by us
+	err = route.Set(ctx, "testReconcile@other.test", "recipient@test.test")
+	assert.NoError(t, err)
+
+	// not in the address format of the strategy, such as ones created by hand
+	handmade := address("test-reconcile")
+	catchAll := address(".*")
+	for _, addr := range []string{handmade, catchAll} {
+		err = route.Set(ctx, addr, "recipient@test.test")
+		assert.NoError(t, err)
+	}
+
+	for i := 0; i < 2; i++ {
+		report, err := r.Reconcile(ctx, true, true)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{orphaned.Value}, report.OrphanedRoutes)
+		assert.ElementsMatch(t, []string{handmade, catchAll}, report.UnknownRoutes)
+		assert.Equal(t, []string{missing.Value}, report.MissingRoutes)
+		assert.Empty(t, report.Repaired)
+	}
+
+	report, err := r.Reconcile(ctx, false, false)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{orphaned.Value, missing.Value}, report.Repaired)
+
+	report, err = r.Reconcile(ctx, true, false)
+	assert.NoError(t, err)
+	assert.Empty(t, report.OrphanedRoutes)
+	assert.ElementsMatch(t, []string{handmade, catchAll}, report.UnknownRoutes)
+	assert.Empty(t, report.MissingRoutes)
+
+	// patterns are kept even if asked
+	report, err = r.Reconcile(ctx, false, true)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{handmade}, report.Repaired)
+
+	report, err = r.Reconcile(ctx, true, true)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{catchAll}, report.UnknownRoutes)
+
+	err = route.Set(ctx, missing.Value, "recipient@test.test")
+	assert.True(t, errors.Is(err, router.ErrorDuplicated))
+	err = route.Unset(ctx, orphaned.Value)
+	assert.True(t, errors.Is(err, router.ErrorUndefined))
+	err = route.Unset(ctx, consistent.Value)
+	assert.NoError(t, err)
+	err = route.Unset(ctx, "testReconcile@other.test")
+	assert.NoError(t, err)
+	err = route.Unset(ctx, catchAll)
+	assert.NoError(t, err)
+}
+
+// hookedStorage calls hook once after the first List
+type hookedStorage struct {
+	storage.Storage
+	hook func()
+	once sync.Once
+}
+
+func (s *hookedStorage) List(ctx context.Context, cursor string, limit int) ([]*storage.Record, string, error) {
+	defer s.once.Do(s.hook)
+	return s.Storage.List(ctx, cursor, limit)
+}
+
+func TestReconcileConcurrentAssign(t *testing.T) {
+	inner := storage.NewMemoryStorage()
+	route := router.NewMockRouter()
+
+	s, err := assign.NewDefaultStrategy(inner, route)
+	if err != nil {
+		t.Skipf("skip default: %v", err)
+	}
+
+	// assigned while reconciling, right after storage is listed
+	var assigned *storage.Record
+	store := &hookedStorage{Storage: inner, hook: func() {
+		assigned, err = s.Assign(ctx, "http://testReconcileConcurrentAssign.test", storage.Metadata{})
+		assert.NoError(t, err)
+	}}
+	r, err := assign.NewReconciler(store, route, s)
+	if err != nil {
+		t.Skipf("skip reconciler: %v", err)
+	}
+
+	report, err := r.Reconcile(ctx, false, false)
+	assert.NoError(t, err)
+	assert.Empty(t, report.OrphanedRoutes)
+	assert.Empty(t, report.Repaired)
+
+	exists, err := route.Exists(ctx, assigned.Value)
+	assert.NoError(t, err)
+	assert.True(t, exists)
+}
+
+func TestReconcileAddressFormats(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	route := router.NewMockRouter()
+
+	prefixed, err := assign.NewConfigurableStrategy(store, route, assign.StrategyOptions{Namespace: "prefixed", Prefix: "p-", Length: 8, Charset: "abc123"})
+	if err != nil {
+		t.Skipf("skip configurable: %v", err)
+	}
+	words, err := assign.NewConfigurableStrategy(store, route, assign.StrategyOptions{Namespace: "words", Format: "words", Words: 3, Separator: "-", Digits: 2})
+	if err != nil {
+		t.Skipf("skip configurable: %v", err)
+	}
+	deterministic := newDeterministicStrategy(t, store, route, "testReconcileAddressFormats")
+
+	r, err := assign.NewReconciler(store, route, prefixed, words, deterministic)
+	if err != nil {
+		t.Skipf("skip reconciler: %v", err)
+	}
+
+	// routes are left behind by removing records
+	orphaned := []string{}
+	for _, s := range []assign.Strategy{prefixed, words, deterministic} {
+		record, err := s.Assign(ctx, "https://testReconcileAddressFormats.test", storage.Metadata{})
+		assert.NoError(t, err)
+		_, err = store.UnsetByKey(ctx, record.Key)
+		assert.NoError(t, err)
+		orphaned = append(orphaned, record.Value)
+	}
+	alias, err := prefixed.AssignAlias(ctx, "https://testReconcileAddressFormats-alias.test", "testalias", storage.Metadata{})
+	assert.NoError(t, err)
+	_, err = store.UnsetByKey(ctx, alias.Key)
+	assert.NoError(t, err)
+
+	unknown := []string{
+		alias.Value,
+		address("p-abcdefgh"),
+		address("p-abc"),
+		address("brave-otter-42"),
+		address("brave-brave-otter"),
+		"p-abc123ab@other.test",
+	}
+	for _, addr := range unknown[1:] {
+		err = route.Set(ctx, addr, "recipient@test.test")
+		assert.NoError(t, err)
+	}
+
+	report, err := r.Reconcile(ctx, true, false)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, orphaned, report.OrphanedRoutes)
+	// routes for other domains are not reported at all
+	assert.ElementsMatch(t, unknown[:len(unknown)-1], report.UnknownRoutes)
+}
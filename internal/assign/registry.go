@@ -0,0 +1,259 @@
+package assign
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kaz/private-email-relay/internal/router"
+	"github.com/kaz/private-email-relay/internal/storage"
+)
+
+type (
+	// Factory creates a strategy from parameters given by configuration.
+	Factory func(store storage.Storage, route router.Router, params Params) (Strategy, error)
+
+	// Params are parameters of a strategy, written as strings in configuration.
+	Params map[string]string
+)
+
+const (
+	overrideParamPrefix = "override."
+)
+
+var (
+	factories   = map[string]Factory{}
+	factoriesMu sync.RWMutex
+)
+
+func init() {
+	Register("default", func(store storage.Storage, route router.Router, params Params) (Strategy, error) {
+		opts, err := params.strategyOptions(defaultStrategyOptions(), false)
+		if err != nil {
+			return nil, err
+		}
+		return newDefaultStrategy(store, route, opts)
+	})
+	Register("temporary", func(store storage.Storage, route router.Router, params Params) (Strategy, error) {
+		opts, err := params.strategyOptions(temporaryStrategyOptions(), false)
+		if err != nil {
+			return nil, err
+		}
+		return newTemporaryStrategy(store, route, opts)
+	})
+	Register("burner", func(store storage.Storage, route router.Router, params Params) (Strategy, error) {
+		opts, err := params.strategyOptions(burnerStrategyOptions(), false)
+		if err != nil {
+			return nil, err
+		}
+		return newBurnerStrategy(store, route, opts)
+	})
+	Register("custom", func(store storage.Storage, route router.Router, params Params) (Strategy, error) {
+		opts, err := params.strategyOptions(defaultStrategyOptions(), true)
+		if err != nil {
+			return nil, err
+		}
+		// keys without namespace belong to "default"
+		if opts.Namespace == "" {
+			return nil, fmt.Errorf("parameter namespace is required")
+		}
+		return NewConfigurableStrategy(store, route, opts)
+	})
+	// presets of "custom" keyed by full hostname, or hostname and first path segment
+	for _, key := range []string{"host", "path"} {
+		key := key
+		Register(key, func(store storage.Storage, route router.Router, params Params) (Strategy, error) {
+			preset := defaultStrategyOptions()
+			preset.Namespace = key
+			preset.Key = key
+			opts, err := params.strategyOptions(preset, true)
+			if err != nil {
+				return nil, err
+			}
+			if opts.Namespace == "" {
+				return nil, fmt.Errorf("parameter namespace must not be empty")
+			}
+			return NewConfigurableStrategy(store, route, opts)
+		})
+	}
+	Register("deterministic", func(store storage.Storage, route router.Router, params Params) (Strategy, error) {
+		if err := params.Check("namespace", "secret", "secret_file", "prefix", "length", "recipient"); err != nil {
+			return nil, err
+		}
+
+		secret := params.String("secret", "")
+		if path := params.String("secret_file", ""); secret == "" && path != "" {
+			content, err := os.ReadFile(path)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read secret_file: %w", err)
+			}
+			secret = strings.TrimSpace(string(content))
+		}
+		if secret == "" {
+			return nil, fmt.Errorf("parameter secret or secret_file is required")
+		}
+
+		length, err := params.Int("length", defaultDeterministicLength)
+		if err != nil {
+			return nil, err
+		}
+
+		strategy, err := NewDeterministicStrategy(store, route, params.String("namespace", "hmac"), []byte(secret), params.String("prefix", ""), length)
+		if err != nil {
+			return nil, err
+		}
+		if recipient := params.String("recipient", ""); recipient != "" {
+			strategy.recipientAddr = recipient
+		}
+		return strategy, nil
+	})
+}
+
+// strategyOptions overrides presets by parameters.
+// namespace of presets is fixed, since changing it orphans assigned keys.
+func (p Params) strategyOptions(opts StrategyOptions, withNamespace bool) (StrategyOptions, error) {
+	known := []string{"key", "prefix", "format", "length", "charset", "entropy", "words", "separator", "digits", "expiry", "recipient", "blocklist", "max_deliveries"}
+	if withNamespace {
+		known = append(known, "namespace")
+	}
+
+	// "override.HOST: RULE" are overrides of key, such as "override.*.myshopify.com: host"
+	rest := Params{}
+	for name, value := range p {
+		if host := strings.TrimPrefix(name, overrideParamPrefix); host != name {
+			if opts.Overrides == nil {
+				opts.Overrides = map[string]string{}
+			}
+			opts.Overrides[host] = value
+			continue
+		}
+		rest[name] = value
+	}
+	if err := rest.Check(known...); err != nil {
+		return opts, err
+	}
+
+	var err error
+	opts.Namespace = p.String("namespace", opts.Namespace)
+	opts.Key = p.String("key", opts.Key)
+	opts.Prefix = p.String("prefix", opts.Prefix)
+	opts.Format = p.String("format", opts.Format)
+	opts.Charset = p.String("charset", opts.Charset)
+	opts.Separator = p.String("separator", opts.Separator)
+	opts.Recipient = p.String("recipient", opts.Recipient)
+	// comma-separated
+	if blocklist := p.String("blocklist", ""); blocklist != "" {
+		opts.Blocklist = strings.Split(blocklist, ",")
+	}
+	if opts.Length, err = p.Int("length", opts.Length); err != nil {
+		return opts, err
+	}
+	if opts.Entropy, err = p.Int("entropy", opts.Entropy); err != nil {
+		return opts, err
+	}
+	if opts.Words, err = p.Int("words", opts.Words); err != nil {
+		return opts, err
+	}
+	if opts.Digits, err = p.Int("digits", opts.Digits); err != nil {
+		return opts, err
+	}
+	if opts.MaxDeliveries, err = p.Int("max_deliveries", opts.MaxDeliveries); err != nil {
+		return opts, err
+	}
+	// "0" never expires
+	if opts.Expiry, err = p.Duration("expiry", opts.Expiry); err != nil {
+		return opts, err
+	}
+	return opts, nil
+}
+
+// Register makes a strategy type available by name. It panics if the name is already taken.
+func Register(typ string, factory Factory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+
+	if _, ok := factories[typ]; ok {
+		panic(fmt.Sprintf("strategy type is registered twice: %v", typ))
+	}
+	factories[typ] = factory
+}
+
+// Types returns names of registered strategy types in order.
+func Types() []string {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+
+	types := make([]string, 0, len(factories))
+	for typ := range factories {
+		types = append(types, typ)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// New creates a strategy of the registered type.
+func New(typ string, store storage.Storage, route router.Router, params Params) (Strategy, error) {
+	factoriesMu.RLock()
+	factory, ok := factories[typ]
+	factoriesMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown strategy type: %q (available: %v)", typ, Types())
+	}
+	return factory(store, route, params)
+}
+
+// Check reports parameters other than known ones, which are likely to be typos.
+func (p Params) Check(known ...string) error {
+	allowed := map[string]bool{}
+	for _, name := range known {
+		allowed[name] = true
+	}
+
+	unknown := []string{}
+	for name := range p {
+		if !allowed[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("unknown parameters: %v (available: %v)", unknown, known)
+	}
+	return nil
+}
+
+func (p Params) String(name, fallback string) string {
+	if value, ok := p[name]; ok {
+		return value
+	}
+	return fallback
+}
+
+func (p Params) Int(name string, fallback int) (int, error) {
+	value, ok := p[name]
+	if !ok {
+		return fallback, nil
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("parameter %v must be an integer: %q", name, value)
+	}
+	return parsed, nil
+}
+
+func (p Params) Duration(name string, fallback time.Duration) (time.Duration, error) {
+	value, ok := p[name]
+	if !ok {
+		return fallback, nil
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("parameter %v must be a duration: %q", name, value)
+	}
+	return parsed, nil
+}
@@ -0,0 +1,97 @@
+package assign_test
+
+import (
+	"testing"
+
+	"github.com/kaz/private-email-relay/internal/assign"
+	"github.com/kaz/private-email-relay/internal/router"
+	"github.com/kaz/private-email-relay/internal/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNew(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	route := router.NewMockRouter()
+
+	s, err := assign.New("temporary", store, route, assign.Params{"expiry": "1h"})
+	if err != nil {
+		t.Skipf("skip temporary: %v", err)
+	}
+	assert.IsType(t, &assign.TemporaryStrategy{}, s)
+
+	_, err = assign.New("unknown", store, route, nil)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "unknown strategy type")
+
+	_, err = assign.New("temporary", store, route, assign.Params{"expiry": "forever"})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "expiry")
+
+	_, err = assign.New("temporary", store, route, assign.Params{"expires": "1h"})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "unknown parameters: [expires]")
+}
+
+func TestNewCustom(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	route := router.NewMockRouter()
+
+	_, err := assign.New("custom", store, route, assign.Params{"key": "host"})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "namespace")
+
+	s, err := assign.New("custom", store, route, assign.Params{"namespace": "shop", "key": "path", "length": "12"})
+	if err != nil {
+		t.Skipf("skip custom: %v", err)
+	}
+	assert.IsType(t, &assign.ConfigurableStrategy{}, s)
+	assert.Equal(t, "shop", s.(*assign.ConfigurableStrategy).Namespace())
+
+	_, err = assign.New("default", store, route, assign.Params{"namespace": "shop"})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "unknown parameters: [namespace]")
+}
+
+func TestNewWords(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	route := router.NewMockRouter()
+
+	s, err := assign.New("default", store, route, assign.Params{"format": "words", "words": "3", "separator": "_"})
+	if err != nil {
+		t.Skipf("skip default: %v", err)
+	}
+
+	record, err := s.Assign(ctx, "http://testNewWords.test", storage.Metadata{})
+	assert.NoError(t, err)
+	// digits of the preset are kept
+	assert.Regexp(t, `^[a-z]+_[a-z]+_[a-z]+_[0-9]{2}@`, record.Value)
+}
+
+func TestNewHostAndPath(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	route := router.NewMockRouter()
+
+	host, err := assign.New("host", store, route, assign.Params{"override.*.github.com": "domain"})
+	if err != nil {
+		t.Skipf("skip host: %v", err)
+	}
+	path, err := assign.New("path", store, route, nil)
+	assert.NoError(t, err)
+
+	record, err := host.Assign(ctx, "https://shop.testNewHostAndPath.test/", storage.Metadata{})
+	assert.NoError(t, err)
+	assert.Equal(t, "host#shop.testnewhostandpath.test", record.Key)
+
+	record, err = host.Assign(ctx, "https://gist.github.com/", storage.Metadata{})
+	assert.NoError(t, err)
+	assert.Equal(t, "host#github.com", record.Key)
+
+	record, err = path.Assign(ctx, "https://testNewHostAndPath.test/shop/1", storage.Metadata{})
+	assert.NoError(t, err)
+	assert.Equal(t, "path#testnewhostandpath.test/shop", record.Key)
+
+	_, err = assign.New("host", store, route, assign.Params{"override.example.com": "query"})
+	assert.Error(t, err)
+	_, err = assign.New("path", store, route, assign.Params{"namespace": ""})
+	assert.Error(t, err)
+}
@@ -1,7 +1,6 @@
 package assign
 
 import (
-	"context"
 	"fmt"
 	"time"
 
@@ -11,55 +10,33 @@ import (
 
 type (
 	TemporaryStrategy struct {
-		*baseStrategy
-
-		deadline deadline
+		*ConfigurableStrategy
 	}
-
-	deadline func() time.Time
 )
 
-func NewTemporaryStrategy(store storage.Storage, route router.Router, deadline deadline) (Strategy, error) {
-	base, err := newBaseStrategy(store, route)
-	if err != nil {
-		return nil, fmt.Errorf("failed to initialize base strategy: %w", err)
+func temporaryStrategyOptions() StrategyOptions {
+	return StrategyOptions{
+		Namespace: "temp",
+		Prefix:    "t-",
+		Entropy:   defaultEntropy,
+		Separator: defaultSeparator,
+		Digits:    defaultDigits,
+		Expiry:    3 * 24 * time.Hour,
 	}
-	return &TemporaryStrategy{base, deadline}, nil
 }
 
-func (s *TemporaryStrategy) keyProducerFactory(url string) producer {
-	return func() (string, error) {
-		key, err := effectiveDomain(url)
-		if err != nil {
-			return "", fmt.Errorf("error occurred while finding effective domain: %w", err)
-		}
-		return fmt.Sprintf("temp#%s", key), nil
+func NewTemporaryStrategy(store storage.Storage, route router.Router, deadline deadline) (Strategy, error) {
+	strategy, err := newTemporaryStrategy(store, route, temporaryStrategyOptions())
+	if err != nil {
+		return nil, err
 	}
+	strategy.(*TemporaryStrategy).deadline = deadline
+	return strategy, nil
 }
-
-func (s *TemporaryStrategy) Assign(ctx context.Context, url string) (string, error) {
-	return s.assignByKey(ctx, s.keyProducerFactory(url), s.addressProducerFactory("t-", 6), s.deadline())
-}
-
-func (s *TemporaryStrategy) Unassign(ctx context.Context, url string) error {
-	return s.unassignByKey(ctx, s.keyProducerFactory(url))
-}
-
-func (s *TemporaryStrategy) UnassignByAddr(ctx context.Context, addr string) error {
-	return s.unassignByAddr(ctx, addr)
-}
-
-func (s *TemporaryStrategy) UnassignExpired(ctx context.Context, until time.Time) (int, error) {
-	deletedAddrs, err := s.store.UnsetExpired(ctx, until)
+func newTemporaryStrategy(store storage.Storage, route router.Router, opts StrategyOptions) (Strategy, error) {
+	configurable, err := NewConfigurableStrategy(store, route, opts)
 	if err != nil {
-		return 0, fmt.Errorf("failed to delete from storage: %w", err)
-	}
-
-	for _, addr := range deletedAddrs {
-		if err := s.route.Unset(ctx, addr); err != nil {
-			return 0, fmt.Errorf("failed to remove route: %w", err)
-		}
+		return nil, fmt.Errorf("failed to initialize configurable strategy: %w", err)
 	}
-
-	return len(deletedAddrs), nil
+	return &TemporaryStrategy{configurable}, nil
 }
@@ -1,15 +1,21 @@
 package assign_test
 
 import (
+	"errors"
 	"testing"
 	"time"
 
 	"github.com/kaz/private-email-relay/internal/assign"
+	"github.com/kaz/private-email-relay/internal/storage"
 	"github.com/stretchr/testify/assert"
 )
 
 func TestUnassignExpired(t *testing.T) {
-	testUnassignExpired(t, implements["temporary"].(*assign.TemporaryStrategy))
+	s, ok := implements["temporary"].(*assign.TemporaryStrategy)
+	if !ok {
+		t.Skip("skip temporary")
+	}
+	testUnassignExpired(t, s)
 }
 func testUnassignExpired(t *testing.T, s *assign.TemporaryStrategy) {
 	urls := []string{
@@ -20,7 +26,7 @@ func testUnassignExpired(t *testing.T, s *assign.TemporaryStrategy) {
 	}
 
 	for _, url := range urls {
-		_, err := s.Assign(ctx, url)
+		_, err := s.Assign(ctx, url, storage.Metadata{})
 		assert.NoError(t, err)
 	}
 
@@ -33,3 +39,88 @@ func testUnassignExpired(t *testing.T, s *assign.TemporaryStrategy) {
 	assert.NoError(t, err)
 	assert.GreaterOrEqual(t, count, len(urls))
 }
+
+func TestUpdateExpiry(t *testing.T) {
+	s, ok := implements["temporary"].(*assign.TemporaryStrategy)
+	if !ok {
+		t.Skip("skip temporary")
+	}
+	testUpdateExpiry(t, s)
+}
+func testUpdateExpiry(t *testing.T, s *assign.TemporaryStrategy) {
+	url := "http://testUpdateExpiry.test"
+
+	record, err := s.Assign(ctx, url, storage.Metadata{})
+	assert.NoError(t, err)
+
+	// made permanent
+	updated, err := s.UpdateExpiry(ctx, url, storage.NeverExpire)
+	assert.NoError(t, err)
+	assert.Equal(t, record.Value, updated.Value)
+	assert.True(t, storage.NeverExpire.Equal(updated.Expires))
+
+	_, err = s.UnassignExpired(ctx, time.Now().Add(48*time.Hour))
+	assert.NoError(t, err)
+	got, err := s.Lookup(ctx, url)
+	assert.NoError(t, err)
+	assert.Equal(t, record.Value, got.Value)
+
+	// made temporary again, by address
+	deadline := time.Now().Add(time.Hour)
+	updated, err = s.UpdateExpiryByAddr(ctx, record.Value, deadline)
+	assert.NoError(t, err)
+	assert.True(t, deadline.Equal(updated.Expires))
+
+	_, err = s.UnassignExpired(ctx, time.Now().Add(2*time.Hour))
+	assert.NoError(t, err)
+	_, err = s.Lookup(ctx, url)
+	assert.True(t, errors.Is(err, storage.ErrorUndefinedKey))
+
+	_, err = s.UpdateExpiry(ctx, url, storage.NeverExpire)
+	assert.True(t, errors.Is(err, storage.ErrorUndefinedKey))
+	_, err = s.UpdateExpiryByAddr(ctx, record.Value, storage.NeverExpire)
+	assert.True(t, errors.Is(err, storage.ErrorUndefinedValue))
+}
+
+func TestUpdateExpiryOfOthers(t *testing.T) {
+	s, ok := implements["default"].(assign.ExpiryUpdater)
+	if !ok {
+		t.Skip("skip default")
+	}
+	burner, ok := implements["burner"]
+	if !ok {
+		t.Skip("skip burner")
+	}
+	scoped, err := implements["default"].(assign.AccountScoped).ForAccount("testupdateexpiryofothers")
+	assert.NoError(t, err)
+
+	for _, other := range []assign.Strategy{burner, scoped} {
+		url := "http://testUpdateExpiryOfOthers.test"
+		record, err := other.Assign(ctx, url, storage.Metadata{})
+		assert.NoError(t, err)
+
+		// not made permanent by another strategy or account
+		_, err = s.UpdateExpiryByAddr(ctx, record.Value, storage.NeverExpire)
+		assert.True(t, errors.Is(err, storage.ErrorUndefinedValue))
+
+		got, err := other.Lookup(ctx, url)
+		assert.NoError(t, err)
+		assert.True(t, record.Expires.Equal(got.Expires))
+
+		_, err = other.Unassign(ctx, url)
+		assert.NoError(t, err)
+	}
+
+	// but by its own
+	updater, ok := scoped.(assign.ExpiryUpdater)
+	assert.True(t, ok)
+	record, err := scoped.Assign(ctx, "http://testUpdateExpiryOfOthers.test", storage.Metadata{})
+	assert.NoError(t, err)
+	deadline := time.Now().Add(time.Hour)
+	updated, err := updater.UpdateExpiryByAddr(ctx, record.Value, deadline)
+	assert.NoError(t, err)
+	assert.True(t, deadline.Equal(updated.Expires))
+
+	_, err = scoped.Unassign(ctx, "http://testUpdateExpiryOfOthers.test")
+	assert.NoError(t, err)
+}
@@ -1,26 +1,46 @@
 package assign
 
 import (
+	"crypto/rand"
 	"fmt"
-	"math/rand"
+	"math"
+	"math/big"
+	"net"
 	"net/url"
-	"time"
+	"strings"
 
+	"golang.org/x/net/idna"
 	"golang.org/x/net/publicsuffix"
 )
 
-var (
-	charset = []byte("abcdefghijklmnopqrstuvwxyz")
-)
-
-func randomString(length int) string {
-	rand.Seed(time.Now().UnixNano())
+// randomIndex returns a uniform random number in [0, n).
+func randomIndex(n int) (int, error) {
+	// rand.Int is uniform, unlike taking modulo of random bytes
+	i, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		return 0, fmt.Errorf("failed to read random number: %w", err)
+	}
+	return int(i.Int64()), nil
+}
 
+func randomString(charset []byte, length int) (string, error) {
 	result := make([]byte, length)
 	for i := range result {
-		result[i] = charset[rand.Int()%len(charset)]
+		n, err := randomIndex(len(charset))
+		if err != nil {
+			return "", err
+		}
+		result[i] = charset[n]
 	}
-	return string(result)
+	return string(result), nil
+}
+
+// lengthForEntropy returns how many characters of charset are needed to carry bits of entropy.
+func lengthForEntropy(charset []byte, bits int) int {
+	if bits <= 0 || len(charset) < 2 {
+		return 0
+	}
+	return int(math.Ceil(float64(bits) / math.Log2(float64(len(charset)))))
 }
 
 func effectiveDomain(rawurl string) (string, error) {
@@ -36,3 +56,41 @@ func effectiveDomain(rawurl string) (string, error) {
 
 	return edom, nil
 }
+
+func hostname(rawurl string) (string, error) {
+	parsed, err := url.Parse(rawurl)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse URL: %w", err)
+	}
+
+	host := strings.TrimSuffix(parsed.Hostname(), ".")
+	if host == "" {
+		return "", fmt.Errorf("no hostname in URL: %v", rawurl)
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.String(), nil
+	}
+
+	// the same host may be written in unicode or punycode
+	host, err = idna.Lookup.ToASCII(host)
+	if err != nil {
+		return "", fmt.Errorf("invalid hostname: %w", err)
+	}
+	return strings.ToLower(host), nil
+}
+
+// hostnameAndPath tells apart sites hosted under paths of the same host, such as "github.com/kaz"
+func hostnameAndPath(rawurl string) (string, error) {
+	host, err := hostname(rawurl)
+	if err != nil {
+		return "", err
+	}
+
+	parsed, _ := url.Parse(rawurl)
+	for _, segment := range strings.Split(parsed.Path, "/") {
+		if segment != "" {
+			return host + "/" + segment, nil
+		}
+	}
+	return host, nil
+}
@@ -0,0 +1,106 @@
+package assign
+
+import (
+	_ "embed"
+	"fmt"
+	"strings"
+)
+
+var (
+	//go:embed adjectives.txt
+	adjectivesFile string
+	//go:embed nouns.txt
+	nounsFile string
+
+	adjectives = strings.Fields(adjectivesFile)
+	nouns      = strings.Fields(nounsFile)
+
+	// separators which are valid in local part of addresses without quoting
+	wordSeparators = map[string]bool{"": true, ".": true, "-": true, "_": true}
+)
+
+// randomWords returns adjectives followed by a noun, such as "brave.otter.42".
+// digits of random number are appended so that aliases of the same words can coexist.
+func randomWords(count int, separator string, digits int) (string, error) {
+	words := make([]string, 0, count+1)
+	for i := 0; i < count; i++ {
+		list := adjectives
+		if i == count-1 {
+			list = nouns
+		}
+		n, err := randomIndex(len(list))
+		if err != nil {
+			return "", err
+		}
+		words = append(words, list[n])
+	}
+
+	if digits > 0 {
+		number, err := randomString([]byte("0123456789"), digits)
+		if err != nil {
+			return "", err
+		}
+		words = append(words, number)
+	}
+	return strings.Join(words, separator), nil
+}
+
+func wordsMatcher(prefix string, count int, separator string, digits int) localMatcher {
+	adjectiveSet, nounSet := wordSet(adjectives), wordSet(nouns)
+	isLetters := func(s string) bool {
+		for _, c := range s {
+			if c < 'a' || 'z' < c {
+				return false
+			}
+		}
+		return s != ""
+	}
+
+	return func(local string) bool {
+		if !strings.HasPrefix(local, prefix) {
+			return false
+		}
+		words := local[len(prefix):]
+
+		if digits > 0 {
+			i := len(words) - len(separator) - digits
+			if i < 0 || words[i:i+len(separator)] != separator {
+				return false
+			}
+			for _, c := range words[i+len(separator):] {
+				if c < '0' || '9' < c {
+					return false
+				}
+			}
+			words = words[:i]
+		}
+
+		// words without separator cannot be told apart
+		if separator == "" {
+			return isLetters(words)
+		}
+		list := strings.Split(words, separator)
+		if len(list) != count {
+			return false
+		}
+		for i, word := range list {
+			if i == count-1 && !nounSet[word] || i < count-1 && !adjectiveSet[word] {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+func checkWords(count int, separator string, digits int) error {
+	if count <= 0 {
+		return fmt.Errorf("words must be positive: %d", count)
+	}
+	if !wordSeparators[separator] {
+		return fmt.Errorf("separator must be one of '.', '-', '_' or empty: %q", separator)
+	}
+	if digits < 0 {
+		return fmt.Errorf("digits must not be negative: %d", digits)
+	}
+	return nil
+}
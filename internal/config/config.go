@@ -0,0 +1,209 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kaz/private-email-relay/internal/assign"
+	"github.com/kaz/private-email-relay/internal/router"
+	"github.com/kaz/private-email-relay/internal/storage"
+	"gopkg.in/yaml.v3"
+)
+
+type (
+	Config struct {
+		Storage    StorageConfig             `yaml:"storage"`
+		Router     RouterConfig              `yaml:"router"`
+		Strategies map[string]StrategyConfig `yaml:"strategies"`
+	}
+
+	StorageConfig struct {
+		// one of storage.Backends(), which reads its own settings from environment variables
+		Backend string      `yaml:"backend"`
+		Cache   CacheConfig `yaml:"cache"`
+	}
+	CacheConfig struct {
+		// disabled if zero
+		Size int      `yaml:"size"`
+		TTL  Duration `yaml:"ttl"`
+	}
+
+	RouterConfig struct {
+		// one of router.Backends(), which reads its own settings from environment variables
+		Backend string `yaml:"backend"`
+	}
+
+	StrategyConfig struct {
+		// one of assign.Types()
+		Type   string        `yaml:"type"`
+		Params assign.Params `yaml:"params"`
+	}
+
+	// Duration is written as "72h" in YAML
+	Duration time.Duration
+)
+
+const (
+	defaultCacheTTL = time.Minute
+)
+
+func (d *Duration) UnmarshalYAML(node *yaml.Node) error {
+	var s string
+	if err := node.Decode(&s); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("line %d: %w", node.Line, err)
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// Open creates the storage of the backend, encrypted if STORAGE_ENCRYPTION_KEY or STORAGE_ENCRYPTION_KEY_FILE is set, and cached if configured.
+func (c StorageConfig) Open(ctx context.Context) (storage.Storage, error) {
+	store, err := storage.OpenWithOptions(ctx, c.Backend, storage.OpenOptions{
+		Encrypted: storage.EncryptionConfigured(),
+		CacheSize: c.Cache.Size,
+		CacheTTL:  time.Duration(c.Cache.TTL),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open storage %v: %w", c.Backend, err)
+	}
+	return store, nil
+}
+
+// Default is used for what neither the file nor environment variables specify.
+func Default() *Config {
+	return &Config{
+		Storage: StorageConfig{
+			Backend: "firestore",
+			Cache:   CacheConfig{TTL: Duration(defaultCacheTTL)},
+		},
+		Router: RouterConfig{
+			Backend: "mailgun",
+		},
+		Strategies: map[string]StrategyConfig{
+			"default":   {Type: "default"},
+			"temporary": {Type: "temporary", Params: assign.Params{"expiry": "72h"}},
+		},
+	}
+}
+
+// Load reads the YAML file at CONFIG_FILE if set, and then overrides it by environment variables.
+// The result is validated, so that misconfiguration is reported on startup.
+func Load() (*Config, error) {
+	cfg := Default()
+
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CONFIG_FILE: %w", err)
+		}
+		if err := cfg.parse(content); err != nil {
+			return nil, fmt.Errorf("failed to parse %v: %w", path, err)
+		}
+	}
+
+	if err := cfg.overrideByEnv(); err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func (c *Config) parse(content []byte) error {
+	strategies := c.Strategies
+	c.Strategies = nil
+
+	dec := yaml.NewDecoder(bytes.NewReader(content))
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil {
+		return err
+	}
+
+	// strategies in the file replace the default ones entirely
+	if c.Strategies == nil {
+		c.Strategies = strategies
+	}
+	return nil
+}
+
+func (c *Config) overrideByEnv() error {
+	if backend := os.Getenv("STORAGE_BACKEND"); backend != "" {
+		c.Storage.Backend = backend
+	}
+	if backend := os.Getenv("ROUTER_BACKEND"); backend != "" {
+		c.Router.Backend = backend
+	}
+	if size := os.Getenv("STORAGE_CACHE_SIZE"); size != "" {
+		parsed, err := strconv.Atoi(size)
+		if err != nil {
+			return fmt.Errorf("STORAGE_CACHE_SIZE must be an integer: %q", size)
+		}
+		c.Storage.Cache.Size = parsed
+	}
+	if ttl := os.Getenv("STORAGE_CACHE_TTL"); ttl != "" {
+		parsed, err := time.ParseDuration(ttl)
+		if err != nil {
+			return fmt.Errorf("STORAGE_CACHE_TTL must be a duration: %q", ttl)
+		}
+		c.Storage.Cache.TTL = Duration(parsed)
+	}
+	return nil
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate reports every problem at once, rather than the first one.
+func (c *Config) Validate() error {
+	problems := []string{}
+
+	if !contains(storage.Backends(), c.Storage.Backend) {
+		problems = append(problems, fmt.Sprintf("storage.backend: unknown backend %q (available: %v)", c.Storage.Backend, storage.Backends()))
+	}
+	if c.Storage.Cache.Size < 0 {
+		problems = append(problems, fmt.Sprintf("storage.cache.size: must not be negative: %d", c.Storage.Cache.Size))
+	}
+	if c.Storage.Cache.Size > 0 && c.Storage.Cache.TTL <= 0 {
+		problems = append(problems, fmt.Sprintf("storage.cache.ttl: must be positive: %v", time.Duration(c.Storage.Cache.TTL)))
+	}
+
+	if !contains(router.Backends(), c.Router.Backend) {
+		problems = append(problems, fmt.Sprintf("router.backend: unknown backend %q (available: %v)", c.Router.Backend, router.Backends()))
+	}
+
+	if len(c.Strategies) == 0 {
+		problems = append(problems, "strategies: at least one strategy is required")
+	}
+	names := make([]string, 0, len(c.Strategies))
+	for name := range c.Strategies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !contains(assign.Types(), c.Strategies[name].Type) {
+			problems = append(problems, fmt.Sprintf("strategies.%v.type: unknown type %q (available: %v)", name, c.Strategies[name].Type, assign.Types()))
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid configuration:\n  %v", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+package config_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kaz/private-email-relay/internal/assign"
+	"github.com/kaz/private-email-relay/internal/config"
+	"github.com/kaz/private-email-relay/internal/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	envs = []string{"CONFIG_FILE", "STORAGE_BACKEND", "ROUTER_BACKEND", "STORAGE_CACHE_SIZE", "STORAGE_CACHE_TTL", "STORAGE_ENCRYPTION_KEY", "STORAGE_ENCRYPTION_KEY_FILE"}
+)
+
+// clearEnv unsets variables read by config, and restores them after the test
+func clearEnv(t *testing.T) {
+	for _, key := range envs {
+		if prev, ok := os.LookupEnv(key); ok {
+			t.Cleanup(func() { os.Setenv(key, prev) })
+		} else {
+			t.Cleanup(func() { os.Unsetenv(key) })
+		}
+		os.Unsetenv(key)
+	}
+}
+
+func writeConfig(t *testing.T, content string) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("CONFIG_FILE", path)
+}
+
+func TestLoadDefault(t *testing.T) {
+	clearEnv(t)
+
+	cfg, err := config.Load()
+	assert.NoError(t, err)
+	assert.Equal(t, config.Default(), cfg)
+}
+
+func TestLoadFile(t *testing.T) {
+	clearEnv(t)
+	writeConfig(t, `
+storage:
+  backend: sqlite
+  cache:
+    size: 100
+    ttl: 30s
+router:
+  backend: mock
+strategies:
+  short:
+    type: temporary
+    params:
+      expiry: 1h
+`)
+
+	cfg, err := config.Load()
+	assert.NoError(t, err)
+	assert.Equal(t, "sqlite", cfg.Storage.Backend)
+	assert.Equal(t, 100, cfg.Storage.Cache.Size)
+	assert.Equal(t, config.Duration(30*time.Second), cfg.Storage.Cache.TTL)
+	assert.Equal(t, "mock", cfg.Router.Backend)
+	assert.Equal(t, map[string]config.StrategyConfig{
+		"short": {Type: "temporary", Params: assign.Params{"expiry": "1h"}},
+	}, cfg.Strategies)
+}
+
+func TestLoadEnvOverridesFile(t *testing.T) {
+	clearEnv(t)
+	writeConfig(t, `
+storage:
+  backend: sqlite
+`)
+	os.Setenv("STORAGE_BACKEND", "bolt")
+	os.Setenv("ROUTER_BACKEND", "mock")
+	os.Setenv("STORAGE_CACHE_SIZE", "10")
+
+	cfg, err := config.Load()
+	assert.NoError(t, err)
+	assert.Equal(t, "bolt", cfg.Storage.Backend)
+	assert.Equal(t, "mock", cfg.Router.Backend)
+	assert.Equal(t, 10, cfg.Storage.Cache.Size)
+	assert.Equal(t, config.Default().Strategies, cfg.Strategies)
+}
+
+func TestLoadInvalid(t *testing.T) {
+	for name, testCase := range map[string]struct {
+		content  string
+		contains []string
+	}{
+		"unknown backends": {
+			content: `
+storage:
+  backend: mysql
+router:
+  backend: sendgrid
+strategies:
+  default:
+    type: unknown
+`,
+			contains: []string{
+				`storage.backend: unknown backend "mysql"`,
+				`router.backend: unknown backend "sendgrid"`,
+				`strategies.default.type: unknown type "unknown"`,
+			},
+		},
+		"typo in field": {
+			content: `
+storage:
+  backnd: sqlite
+`,
+			contains: []string{"field backnd not found"},
+		},
+		"invalid duration": {
+			content: `
+storage:
+  cache:
+    ttl: forever
+`,
+			contains: []string{"forever"},
+		},
+		"no strategies": {
+			content: `
+strategies: {}
+`,
+			contains: []string{"at least one strategy is required"},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			clearEnv(t)
+			writeConfig(t, testCase.content)
+
+			_, err := config.Load()
+			if assert.Error(t, err) {
+				for _, s := range testCase.contains {
+					assert.Contains(t, err.Error(), s)
+				}
+			}
+		})
+	}
+}
+
+func TestStorageOpen(t *testing.T) {
+	clearEnv(t)
+	ctx := context.Background()
+
+	store, err := config.StorageConfig{Backend: "memory"}.Open(ctx)
+	assert.NoError(t, err)
+	assert.IsType(t, storage.NewMemoryStorage(), store)
+
+	store, err = config.StorageConfig{Backend: "memory", Cache: config.CacheConfig{Size: 10}}.Open(ctx)
+	assert.NoError(t, err)
+	assert.IsType(t, &storage.CachedStorage{}, store)
+
+	os.Setenv("STORAGE_ENCRYPTION_KEY", "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=")
+	store, err = config.StorageConfig{Backend: "memory"}.Open(ctx)
+	assert.NoError(t, err)
+	assert.IsType(t, &storage.EncryptedStorage{}, store)
+
+	_, err = config.StorageConfig{Backend: "unknown"}.Open(ctx)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "failed to open storage unknown")
+	}
+}
@@ -3,6 +3,8 @@ package router
 import (
 	"context"
 	"fmt"
+	"regexp"
+	"strings"
 
 	"github.com/mailgun/mailgun-go/v4"
 )
@@ -13,6 +15,11 @@ type (
 	}
 )
 
+var (
+	mailgunExpressionPattern = regexp.MustCompile(`^match_recipient\("(.+)"\)$`)
+	mailgunForwardPattern    = regexp.MustCompile(`^forward\("(.+)"\)$`)
+)
+
 func NewMailgunRouter() (Router, error) {
 	client, err := mailgun.NewMailgunFromEnv()
 	if err != nil {
@@ -21,9 +28,39 @@ func NewMailgunRouter() (Router, error) {
 	return &MailgunRouter{client}, nil
 }
 
+// addresses are quoted and anchored, not to match mails to other addresses
 func (r *MailgunRouter) createExpression(from string) string {
+	return fmt.Sprintf("match_recipient(\"^%s$\")", regexp.QuoteMeta(from))
+}
+
+// routes created before addresses were quoted
+func (r *MailgunRouter) createLegacyExpression(from string) string {
 	return fmt.Sprintf("match_recipient(\"%s\")", from)
 }
+
+// parseExpression returns the address matched by a quoted and anchored pattern, or the pattern itself otherwise.
+func (r *MailgunRouter) parseExpression(pattern string) string {
+	if !strings.HasPrefix(pattern, "^") || !strings.HasSuffix(pattern, "$") {
+		return pattern
+	}
+
+	var from strings.Builder
+	quoted := pattern[1 : len(pattern)-1]
+	for i := 0; i < len(quoted); i++ {
+		c := quoted[i]
+		if c == '\\' && i+1 < len(quoted) {
+			i++
+			from.WriteByte(quoted[i])
+			continue
+		}
+		// not a literal address, such as a route created by hand
+		if strings.IndexByte(`\.+*?()|[]{}^$`, c) >= 0 {
+			return pattern
+		}
+		from.WriteByte(c)
+	}
+	return from.String()
+}
 func (r *MailgunRouter) createRoute(from, to string) mailgun.Route {
 	return mailgun.Route{
 		Expression: r.createExpression(from),
@@ -37,13 +74,14 @@ func (r *MailgunRouter) createRoute(from, to string) mailgun.Route {
 
 func (r *MailgunRouter) findRoute(ctx context.Context, from string) (*mailgun.Route, error) {
 	expression := r.createExpression(from)
+	legacyExpression := r.createLegacyExpression(from)
 
 	iter := r.client.ListRoutes(nil)
 	results := []mailgun.Route{}
 
 	for iter.Next(ctx, &results) {
 		for _, route := range results {
-			if route.Expression == expression {
+			if route.Expression == expression || route.Expression == legacyExpression {
 				return &route, nil
 			}
 		}
@@ -83,3 +121,37 @@ func (r *MailgunRouter) Unset(ctx context.Context, from string) error {
 	}
 	return nil
 }
+
+func (r *MailgunRouter) Exists(ctx context.Context, from string) (bool, error) {
+	route, err := r.findRoute(ctx, from)
+	if err != nil {
+		return false, fmt.Errorf("failed to find route: %w", err)
+	}
+	return route != nil, nil
+}
+
+func (r *MailgunRouter) List(ctx context.Context) ([]*Route, error) {
+	iter := r.client.ListRoutes(nil)
+	results := []mailgun.Route{}
+	routes := []*Route{}
+
+	for iter.Next(ctx, &results) {
+		for _, route := range results {
+			// skip routes not created by this router
+			expression := mailgunExpressionPattern.FindStringSubmatch(route.Expression)
+			if expression == nil || len(route.Actions) == 0 {
+				continue
+			}
+			forward := mailgunForwardPattern.FindStringSubmatch(route.Actions[0])
+			if forward == nil {
+				continue
+			}
+			routes = append(routes, &Route{r.parseExpression(expression[1]), forward[1]})
+		}
+	}
+
+	if err := iter.Err(); err != nil {
+		return nil, fmt.Errorf("an error occurred while listing routes: %w", err)
+	}
+	return routes, nil
+}
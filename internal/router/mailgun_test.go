@@ -0,0 +1,32 @@
+package router
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMailgunExpression(t *testing.T) {
+	r := &MailgunRouter{}
+
+	for _, from := range []string{"abcd@test.test", "brave.otter.42@test.test", "a.+@test.test", "shop-2021_x@test.test"} {
+		expression := mailgunExpressionPattern.FindStringSubmatch(r.createExpression(from))
+		if !assert.NotNil(t, expression, from) {
+			continue
+		}
+		assert.Equal(t, from, r.parseExpression(expression[1]))
+
+		// matches the address only, not others sharing its prefix
+		pattern := regexp.MustCompile(expression[1])
+		assert.True(t, pattern.MatchString(from), from)
+		assert.False(t, pattern.MatchString("x"+from), from)
+		assert.False(t, pattern.MatchString(from+"x"), from)
+	}
+	assert.False(t, regexp.MustCompile(mailgunExpressionPattern.FindStringSubmatch(r.createExpression("a.b@test.test"))[1]).MatchString("axb@test.test"))
+
+	// patterns created by hand or before quoting are returned as they are
+	for _, pattern := range []string{".*@test.test", "^.*@test\\.test$", "legacy@test.test", "^(foo|bar)@test\\.test$"} {
+		assert.Equal(t, pattern, r.parseExpression(pattern))
+	}
+}
@@ -9,6 +9,13 @@ import (
 type (
 	MockRouter struct {
 		data sync.Map
+
+		faults []mockFault
+		mu     sync.Mutex
+	}
+	mockFault struct {
+		err     error
+		applied bool
 	}
 )
 
@@ -16,15 +23,67 @@ func NewMockRouter() Router {
 	return &MockRouter{}
 }
 
+// InjectFault makes the next call of Set or Unset fail with err.
+// If applied is true, the call takes effect before failing, as if its response were lost.
+func (r *MockRouter) InjectFault(err error, applied bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.faults = append(r.faults, mockFault{err, applied})
+}
+
+func (r *MockRouter) popFault() (mockFault, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.faults) == 0 {
+		return mockFault{}, false
+	}
+
+	fault := r.faults[0]
+	r.faults = r.faults[1:]
+	return fault, true
+}
+
 func (r *MockRouter) Set(ctx context.Context, from, to string) error {
+	fault, faulty := r.popFault()
+	if faulty && !fault.applied {
+		return fault.err
+	}
+
 	if _, loaded := r.data.LoadOrStore(from, to); loaded {
 		return fmt.Errorf("%w: %v", ErrorDuplicated, from)
 	}
+
+	if faulty {
+		return fault.err
+	}
 	return nil
 }
 func (r *MockRouter) Unset(ctx context.Context, from string) error {
+	fault, faulty := r.popFault()
+	if faulty && !fault.applied {
+		return fault.err
+	}
+
 	if _, loaded := r.data.LoadAndDelete(from); !loaded {
 		return fmt.Errorf("%w: %v", ErrorUndefined, from)
 	}
+
+	if faulty {
+		return fault.err
+	}
 	return nil
 }
+func (r *MockRouter) Exists(ctx context.Context, from string) (bool, error) {
+	_, ok := r.data.Load(from)
+	return ok, nil
+}
+func (r *MockRouter) List(ctx context.Context) ([]*Route, error) {
+	routes := []*Route{}
+	r.data.Range(func(from, to interface{}) bool {
+		routes = append(routes, &Route{from.(string), to.(string)})
+		return true
+	})
+	return routes, nil
+}
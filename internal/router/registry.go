@@ -0,0 +1,61 @@
+package router
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+type (
+	// Factory creates a router, which is configured by environment variables.
+	Factory func() (Router, error)
+)
+
+var (
+	factories   = map[string]Factory{}
+	factoriesMu sync.RWMutex
+)
+
+func init() {
+	Register("mailgun", NewMailgunRouter)
+	// keeps routes in memory, for local development
+	Register("mock", func() (Router, error) {
+		return NewMockRouter(), nil
+	})
+}
+
+// Register makes a router backend available by name. It panics if the name is already taken.
+func Register(name string, factory Factory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+
+	if _, ok := factories[name]; ok {
+		panic(fmt.Sprintf("router backend is registered twice: %v", name))
+	}
+	factories[name] = factory
+}
+
+// Backends returns names of registered router backends in order.
+func Backends() []string {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Open creates the router registered as backend.
+func Open(backend string) (Router, error) {
+	factoriesMu.RLock()
+	factory, ok := factories[backend]
+	factoriesMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown router backend: %q (available: %v)", backend, Backends())
+	}
+	return factory()
+}
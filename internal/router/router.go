@@ -11,6 +11,15 @@ type (
 		Set(ctx context.Context, from, to string) error
 		// returns ErrorUndefined
 		Unset(ctx context.Context, from string) error
+		// returns [Nothing]
+		Exists(ctx context.Context, from string) (exists bool, err error)
+		// returns [Nothing]
+		List(ctx context.Context) (routes []*Route, err error)
+	}
+
+	Route struct {
+		From string
+		To   string
 	}
 )
 
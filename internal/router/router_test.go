@@ -87,3 +87,59 @@ func testUnsetUndefined(t *testing.T, r router.Router) {
 	assert.Error(t, err)
 	assert.True(t, errors.Is(err, router.ErrorUndefined))
 }
+
+func TestList(t *testing.T) {
+	for name, impl := range implements {
+		t.Run(name, func(t *testing.T) {
+			testList(t, impl)
+		})
+	}
+}
+func testList(t *testing.T, r router.Router) {
+	from := "testList@test.test"
+	to := "recipient@test.test"
+
+	err := r.Set(ctx, from, to)
+	assert.NoError(t, err)
+
+	routes, err := r.List(ctx)
+	assert.NoError(t, err)
+	assert.Contains(t, routes, &router.Route{From: from, To: to})
+
+	err = r.Unset(ctx, from)
+	assert.NoError(t, err)
+
+	routes, err = r.List(ctx)
+	assert.NoError(t, err)
+	assert.NotContains(t, routes, &router.Route{From: from, To: to})
+}
+
+func TestExists(t *testing.T) {
+	for name, impl := range implements {
+		t.Run(name, func(t *testing.T) {
+			testExists(t, impl)
+		})
+	}
+}
+func testExists(t *testing.T, r router.Router) {
+	from := "testExists@test.test"
+	to := "recipient@test.test"
+
+	exists, err := r.Exists(ctx, from)
+	assert.NoError(t, err)
+	assert.False(t, exists)
+
+	err = r.Set(ctx, from, to)
+	assert.NoError(t, err)
+
+	exists, err = r.Exists(ctx, from)
+	assert.NoError(t, err)
+	assert.True(t, exists)
+
+	err = r.Unset(ctx, from)
+	assert.NoError(t, err)
+
+	exists, err = r.Exists(ctx, from)
+	assert.NoError(t, err)
+	assert.False(t, exists)
+}
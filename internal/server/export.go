@@ -0,0 +1,349 @@
+package server
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kaz/private-email-relay/internal/assign"
+	"github.com/kaz/private-email-relay/internal/storage"
+	"github.com/labstack/echo/v4"
+)
+
+type (
+	GetRelayExportRequest struct {
+		Format string `query:"format"`
+	}
+
+	relayWriter interface {
+		Write(relay *Relay) error
+		Flush() error
+	}
+	relayReader interface {
+		// returns io.EOF
+		Read() (*Relay, error)
+	}
+
+	ndjsonRelayWriter struct {
+		enc *json.Encoder
+	}
+	ndjsonRelayReader struct {
+		dec *json.Decoder
+	}
+	csvRelayWriter struct {
+		w           *csv.Writer
+		wroteHeader bool
+	}
+	csvRelayReader struct {
+		r       *csv.Reader
+		columns map[string]int
+	}
+)
+
+const (
+	formatNDJSON = "ndjson"
+	formatCSV    = "csv"
+
+	mimeNDJSON = "application/x-ndjson"
+	mimeCSV    = "text/csv"
+)
+
+var (
+	csvColumns = []string{"key", "address", "strategy", "domain", "account", "expires", "deliveries", "max_deliveries", "label", "note", "tags", "created_at", "updated_at"}
+)
+
+func (w *ndjsonRelayWriter) Write(relay *Relay) error {
+	return w.enc.Encode(relay)
+}
+func (w *ndjsonRelayWriter) Flush() error {
+	return nil
+}
+
+func (r *ndjsonRelayReader) Read() (*Relay, error) {
+	relay := &Relay{}
+	if err := r.dec.Decode(relay); err != nil {
+		return nil, err
+	}
+	return relay, nil
+}
+
+func formatCSVTime(t *time.Time) string {
+	if t == nil || t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339Nano)
+}
+func parseCSVTime(s string) (*time.Time, error) {
+	if s == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// empty cells are zero, such as ones of files exported before the column was added
+func parseCSVInt(s string) (int, error) {
+	if s == "" {
+		return 0, nil
+	}
+	return strconv.Atoi(s)
+}
+
+func (w *csvRelayWriter) Write(relay *Relay) error {
+	if !w.wroteHeader {
+		if err := w.w.Write(csvColumns); err != nil {
+			return err
+		}
+		w.wroteHeader = true
+	}
+	return w.w.Write([]string{
+		relay.Key,
+		relay.Address,
+		relay.Strategy,
+		relay.Domain,
+		relay.Account,
+		formatCSVTime(relay.Expires),
+		strconv.Itoa(relay.Deliveries),
+		strconv.Itoa(relay.MaxDeliveries),
+		relay.Label,
+		relay.Note,
+		// tags are joined by comma, in a single cell
+		strings.Join(relay.Tags, ","),
+		formatCSVTime(&relay.CreatedAt),
+		formatCSVTime(&relay.UpdatedAt),
+	})
+}
+func (w *csvRelayWriter) Flush() error {
+	w.w.Flush()
+	return w.w.Error()
+}
+
+func (r *csvRelayReader) Read() (*Relay, error) {
+	if r.columns == nil {
+		header, err := r.r.Read()
+		if err != nil {
+			return nil, err
+		}
+		r.columns = map[string]int{}
+		for i, column := range header {
+			r.columns[strings.TrimSpace(column)] = i
+		}
+	}
+
+	row, err := r.r.Read()
+	if err != nil {
+		return nil, err
+	}
+	cell := func(column string) string {
+		if i, ok := r.columns[column]; ok {
+			return row[i]
+		}
+		return ""
+	}
+
+	relay := &Relay{
+		Key:      cell("key"),
+		Address:  cell("address"),
+		Strategy: cell("strategy"),
+		Domain:   cell("domain"),
+		Account:  cell("account"),
+		Label:    cell("label"),
+		Note:     cell("note"),
+		Tags:     []string{},
+	}
+	for _, tag := range strings.Split(cell("tags"), ",") {
+		if tag != "" {
+			relay.Tags = append(relay.Tags, tag)
+		}
+	}
+
+	if relay.Expires, err = parseCSVTime(cell("expires")); err != nil {
+		return nil, fmt.Errorf("invalid expires: %w", err)
+	}
+	if relay.Deliveries, err = parseCSVInt(cell("deliveries")); err != nil {
+		return nil, fmt.Errorf("invalid deliveries: %w", err)
+	}
+	if relay.MaxDeliveries, err = parseCSVInt(cell("max_deliveries")); err != nil {
+		return nil, fmt.Errorf("invalid max_deliveries: %w", err)
+	}
+	if createdAt, err := parseCSVTime(cell("created_at")); err != nil {
+		return nil, fmt.Errorf("invalid created_at: %w", err)
+	} else if createdAt != nil {
+		relay.CreatedAt = *createdAt
+	}
+	if updatedAt, err := parseCSVTime(cell("updated_at")); err != nil {
+		return nil, fmt.Errorf("invalid updated_at: %w", err)
+	} else if updatedAt != nil {
+		relay.UpdatedAt = *updatedAt
+	}
+	return relay, nil
+}
+
+// strategy, domain and account are derived from key, so they are ignored
+func recordFromRelay(relay *Relay) (*storage.Record, error) {
+	if relay.Key == "" {
+		return nil, fmt.Errorf("`key` is required")
+	}
+	if relay.Address == "" {
+		return nil, fmt.Errorf("`address` is required")
+	}
+
+	record := &storage.Record{
+		Key:           relay.Key,
+		Value:         relay.Address,
+		Expires:       storage.NeverExpire,
+		Deliveries:    relay.Deliveries,
+		MaxDeliveries: relay.MaxDeliveries,
+		Metadata: storage.Metadata{
+			Label: relay.Label,
+			Note:  relay.Note,
+			Tags:  relay.Tags,
+		},
+		CreatedAt: relay.CreatedAt,
+		UpdatedAt: relay.UpdatedAt,
+	}
+	if relay.Expires != nil {
+		record.Expires = *relay.Expires
+	}
+	if len(record.Tags) == 0 {
+		record.Tags = nil
+	}
+
+	now := time.Now()
+	if record.CreatedAt.IsZero() {
+		record.CreatedAt = now
+	}
+	if record.UpdatedAt.IsZero() {
+		record.UpdatedAt = record.CreatedAt
+	}
+	return record, nil
+}
+
+func (s *Server) getRelayExport(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	params := &GetRelayExportRequest{}
+	if err := c.Bind(params); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("failed to parse request: %v", err))
+	}
+	if params.Format == "" {
+		params.Format = formatNDJSON
+	}
+
+	res := c.Response()
+
+	var w relayWriter
+	switch params.Format {
+	case formatNDJSON:
+		res.Header().Set(echo.HeaderContentType, mimeNDJSON)
+		w = &ndjsonRelayWriter{json.NewEncoder(res)}
+	case formatCSV:
+		res.Header().Set(echo.HeaderContentType, mimeCSV)
+		w = &csvRelayWriter{w: csv.NewWriter(res)}
+	default:
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("unknown format: %v", params.Format))
+	}
+	res.Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=relays.%v", params.Format))
+	res.WriteHeader(http.StatusOK)
+
+	// status is already sent, so errors below only cut the response short
+	for cursor, first := "", true; first || cursor != ""; first = false {
+		records, nextCursor, err := s.store.List(ctx, cursor, maxListLimit)
+		if err != nil {
+			return fmt.Errorf("failed to list relays: %w", err)
+		}
+		for _, record := range records {
+			if err := w.Write(s.describeRecord(record)); err != nil {
+				return fmt.Errorf("failed to write relay: %w", err)
+			}
+		}
+		if err := w.Flush(); err != nil {
+			return fmt.Errorf("failed to write relays: %w", err)
+		}
+		res.Flush()
+		cursor = nextCursor
+	}
+	return nil
+}
+
+func (s *Server) postRelayImport(c echo.Context) error {
+	ctx := c.Request().Context()
+	req := c.Request()
+
+	format := c.QueryParam("format")
+	if format == "" {
+		format = formatNDJSON
+		if strings.HasPrefix(req.Header.Get(echo.HeaderContentType), mimeCSV) {
+			format = formatCSV
+		}
+	}
+
+	var r relayReader
+	switch format {
+	case formatNDJSON:
+		r = &ndjsonRelayReader{json.NewDecoder(req.Body)}
+	case formatCSV:
+		r = &csvRelayReader{r: csv.NewReader(req.Body)}
+	default:
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("unknown format: %v", format))
+	}
+
+	conflict := c.QueryParam("conflict")
+	if conflict == "" {
+		conflict = string(assign.ConflictFail)
+	}
+	policy, err := assign.ParseConflictPolicy(conflict)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	results := map[assign.ImportResult][]string{
+		assign.ImportCreated:     {},
+		assign.ImportOverwritten: {},
+		assign.ImportSkipped:     {},
+	}
+	respond := func(code int, message string) error {
+		return c.JSON(code, map[string]interface{}{
+			"message":     message,
+			"created":     results[assign.ImportCreated],
+			"overwritten": results[assign.ImportOverwritten],
+			"skipped":     results[assign.ImportSkipped],
+		})
+	}
+
+	// records before the failed one are kept imported
+	for n := 1; ; n++ {
+		relay, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return respond(http.StatusBadRequest, fmt.Sprintf("failed to parse relay #%d: %v", n, err))
+		}
+
+		record, err := recordFromRelay(relay)
+		if err != nil {
+			return respond(http.StatusBadRequest, fmt.Sprintf("invalid relay #%d: %v", n, err))
+		}
+
+		result, err := s.importer.Import(ctx, record, policy)
+		if errors.Is(err, assign.ErrorInvalidAlias) {
+			return respond(http.StatusBadRequest, fmt.Sprintf("invalid relay #%d: %v", n, err))
+		} else if errors.Is(err, storage.ErrorDuplicatedKey) || errors.Is(err, storage.ErrorDuplicatedValue) {
+			return respond(http.StatusConflict, fmt.Sprintf("relay #%d conflicts: %v", n, err))
+		} else if err != nil {
+			return respond(http.StatusInternalServerError, fmt.Sprintf("failed to import relay #%d: %v", n, err))
+		}
+		results[result] = append(results[result], record.Key)
+	}
+
+	return respond(http.StatusOK, "ok")
+}
@@ -1,30 +1,239 @@
 package server
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
+	"sort"
 	"time"
 
 	"github.com/kaz/private-email-relay/internal/assign"
+	"github.com/kaz/private-email-relay/internal/router"
+	"github.com/kaz/private-email-relay/internal/storage"
 	"github.com/labstack/echo/v4"
 )
 
 type (
+	GetRelayRequest struct {
+		URL      string `query:"url"`
+		Cursor   string `query:"cursor"`
+		Limit    int    `query:"limit"`
+		Strategy string `query:"strategy"`
+		Account  string `query:"account"`
+		Domain   string `query:"domain"`
+	}
+	GetRelaySiteRequest struct {
+		URL      string `query:"url"`
+		Strategy string `query:"strategy"`
+		// accounts to look up besides the unscoped one, such as "?account=personal&account=team"
+		Accounts []string `query:"account"`
+	}
 	PostRelayRequest struct {
+		URL      string   `json:"url"`
+		Strategy string   `json:"strategy"`
+		Account  string   `json:"account"`
+		Alias    string   `json:"alias"`
+		Label    string   `json:"label"`
+		Note     string   `json:"note"`
+		Tags     []string `json:"tags"`
+	}
+	DeleteRelayRequst struct {
 		URL      string `json:"url"`
+		Address  string `json:"address"`
 		Strategy string `json:"strategy"`
+		Account  string `json:"account"`
 	}
-	DeleteRelayRequst struct {
+	PatchRelayRequest struct {
 		URL      string `json:"url"`
 		Address  string `json:"address"`
 		Strategy string `json:"strategy"`
+		Account  string `json:"account"`
+		// RFC 3339 time, duration from now such as "72h", or "never"
+		Expires string `json:"expires"`
+	}
+	PostRelayReconcileRequest struct {
+		DryRun bool `json:"dry_run"`
+		// routes neither stored nor in address formats of strategies are only reported unless set
+		RemoveUnknown bool `json:"remove_unknown"`
+	}
+
+	Relay struct {
+		Key        string     `json:"key"`
+		Address    string     `json:"address"`
+		Strategy   string     `json:"strategy"`
+		Domain     string     `json:"domain"`
+		Account    string     `json:"account"`
+		Expires    *time.Time `json:"expires"`
+		Deliveries int        `json:"deliveries"`
+		// unassigned after as many deliveries, and never if zero
+		MaxDeliveries int       `json:"max_deliveries"`
+		Label         string    `json:"label"`
+		Note          string    `json:"note"`
+		Tags          []string  `json:"tags"`
+		CreatedAt     time.Time `json:"created_at"`
+		UpdatedAt     time.Time `json:"updated_at"`
 	}
 )
 
-func (s *Server) postRelay(c echo.Context) error {
+const (
+	defaultListLimit = 100
+	maxListLimit     = 1000
+)
+
+func (s *Server) describeRecord(record *storage.Record) *Relay {
+	relay := &Relay{
+		Key:           record.Key,
+		Address:       record.Value,
+		Account:       assign.ParseAccount(record.Key),
+		Deliveries:    record.Deliveries,
+		MaxDeliveries: record.MaxDeliveries,
+		Label:         record.Label,
+		Note:          record.Note,
+		Tags:          record.Tags,
+		CreatedAt:     record.CreatedAt,
+		UpdatedAt:     record.UpdatedAt,
+	}
+	if relay.Tags == nil {
+		relay.Tags = []string{}
+	}
+	if !record.Expires.Equal(storage.NeverExpire) {
+		relay.Expires = &record.Expires
+	}
+	// in order of names, so that the same strategy is reported every time if more than one parse the key
+	for _, name := range s.strategyNames() {
+		if domain, ok := s.assigners[name].ParseKey(record.Key); ok {
+			relay.Strategy = name
+			relay.Domain = domain
+			break
+		}
+	}
+	return relay
+}
+func (s *Server) strategyNames() []string {
+	names := make([]string, 0, len(s.assigners))
+	for name := range s.assigners {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// strategy returns the strategy scoped to account if given.
+func (s *Server) strategy(name, account string) (assign.Strategy, error) {
+	assigner, ok := s.assigners[name]
+	if !ok {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("no such strategy: %v", name))
+	}
+	if account == "" {
+		return assigner, nil
+	}
+
+	scoped, ok := assigner.(assign.AccountScoped)
+	if !ok {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("strategy does not accept `account`: %v", name))
+	}
+	assigner, err := scoped.ForAccount(account)
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return assigner, nil
+}
+
+func (s *Server) getRelay(c echo.Context) error {
 	ctx := c.Request().Context()
 
-	params := &PostRelayRequest{}
+	params := &GetRelayRequest{}
+	if err := c.Bind(params); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("failed to parse request: %v", err))
+	}
+	if params.URL != "" {
+		return s.lookupRelay(c, params)
+	}
+	if params.Limit <= 0 {
+		params.Limit = defaultListLimit
+	}
+	if params.Limit > maxListLimit {
+		params.Limit = maxListLimit
+	}
+	if params.Strategy != "" {
+		if _, ok := s.assigners[params.Strategy]; !ok {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("no such strategy: %v", params.Strategy))
+		}
+	}
+
+	relays := []*Relay{}
+	cursor := params.Cursor
+	for {
+		records, nextCursor, err := s.store.List(ctx, cursor, params.Limit)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("failed to list relays: %v", err))
+		}
+		cursor = nextCursor
+
+		for i, record := range records {
+			relay := s.describeRecord(record)
+			if params.Strategy != "" && relay.Strategy != params.Strategy {
+				continue
+			}
+			if params.Domain != "" && relay.Domain != params.Domain {
+				continue
+			}
+			if params.Account != "" && relay.Account != params.Account {
+				continue
+			}
+
+			relays = append(relays, relay)
+			if len(relays) == params.Limit {
+				if i < len(records)-1 {
+					cursor = record.Key
+				}
+				break
+			}
+		}
+
+		if len(relays) == params.Limit || cursor == "" {
+			break
+		}
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"message": "ok",
+		"relays":  relays,
+		"cursor":  cursor,
+	})
+}
+
+func (s *Server) lookupRelay(c echo.Context, params *GetRelayRequest) error {
+	ctx := c.Request().Context()
+
+	if params.Strategy == "" {
+		params.Strategy = "default"
+	}
+
+	assigner, err := s.strategy(params.Strategy, params.Account)
+	if err != nil {
+		return err
+	}
+
+	record, err := assigner.Lookup(ctx, params.URL)
+	if err != nil {
+		if errors.Is(err, storage.ErrorUndefinedKey) {
+			return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("no address is assigned: %v", params.URL))
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("failed to lookup address: %v", err))
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"message": "ok",
+		"address": record.Value,
+		"relay":   s.describeRecord(record),
+	})
+}
+
+// getRelaySite lists aliases of every account on the site of url.
+func (s *Server) getRelaySite(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	params := &GetRelaySiteRequest{}
 	if err := c.Bind(params); err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("failed to parse request: %v", err))
 	}
@@ -39,14 +248,93 @@ func (s *Server) postRelay(c echo.Context) error {
 	if !ok {
 		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("no such strategy: %v", params.Strategy))
 	}
+	scoped, ok := assigner.(assign.AccountScoped)
+	if !ok {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("strategy does not support accounts: %v", params.Strategy))
+	}
+	domain, err := scoped.Site(params.URL)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("failed to determine site: %v", err))
+	}
 
-	addr, err := assigner.Assign(ctx, params.URL)
+	// keys of accounts are not adjacent in storage, nor even ordered if encrypted,
+	// so that each account is looked up by its key instead of scanning every record
+	relays := []*Relay{}
+	seen := map[string]bool{}
+	for _, account := range append([]string{""}, params.Accounts...) {
+		looker, err := s.strategy(params.Strategy, account)
+		if err != nil {
+			return err
+		}
+		record, err := looker.Lookup(ctx, params.URL)
+		if errors.Is(err, storage.ErrorUndefinedKey) {
+			continue
+		} else if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("failed to lookup relay: %v", err))
+		}
+		// accounts differing in case are the same
+		if !seen[record.Key] {
+			seen[record.Key] = true
+			relays = append(relays, s.describeRecord(record))
+		}
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"message": "ok",
+		"domain":  domain,
+		"relays":  relays,
+	})
+}
+
+func (s *Server) postRelay(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	params := &PostRelayRequest{}
+	if err := c.Bind(params); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("failed to parse request: %v", err))
+	}
+	if params.URL == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("`url` is required"))
+	}
+	if params.Strategy == "" {
+		params.Strategy = "default"
+	}
+
+	assigner, err := s.strategy(params.Strategy, params.Account)
+	if err != nil {
+		return err
+	}
+
+	meta := storage.Metadata{
+		Label: params.Label,
+		Note:  params.Note,
+		Tags:  params.Tags,
+	}
+
+	var record *storage.Record
+
+	if params.Alias != "" {
+		aliasAssigner, ok := assigner.(assign.AliasAssigner)
+		if !ok {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("strategy does not accept `alias`: %v", params.Strategy))
+		}
+		record, err = aliasAssigner.AssignAlias(ctx, params.URL, params.Alias, meta)
+	} else {
+		record, err = assigner.Assign(ctx, params.URL, meta)
+	}
 	if err != nil {
+		if errors.Is(err, assign.ErrorInvalidAlias) {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		if errors.Is(err, storage.ErrorDuplicatedKey) || errors.Is(err, storage.ErrorDuplicatedValue) || errors.Is(err, router.ErrorDuplicated) {
+			return echo.NewHTTPError(http.StatusConflict, fmt.Sprintf("address is already taken: %v", err))
+		}
 		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("failed to assign address: %v", err))
 	}
-	return c.JSON(http.StatusOK, map[string]string{
+	return c.JSON(http.StatusOK, map[string]interface{}{
 		"message": "ok",
-		"address": addr,
+		"address": record.Value,
+		"relay":   s.describeRecord(record),
 	})
 }
 
@@ -61,17 +349,19 @@ func (s *Server) deleteRelay(c echo.Context) error {
 		params.Strategy = "default"
 	}
 
-	assigner, ok := s.assigners[params.Strategy]
-	if !ok {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("no such strategy: %v", params.Strategy))
+	assigner, err := s.strategy(params.Strategy, params.Account)
+	if err != nil {
+		return err
 	}
 
+	var record *storage.Record
+
 	if params.URL != "" {
-		if err := assigner.Unassign(ctx, params.URL); err != nil {
+		if record, err = assigner.Unassign(ctx, params.URL); err != nil {
 			return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("failed to unassign by url: %v", err))
 		}
 	} else if params.Address != "" {
-		if err := assigner.UnassignByAddr(ctx, params.Address); err != nil {
+		if record, err = assigner.UnassignByAddr(ctx, params.Address); err != nil {
 			return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("failed to unassign by address: %v", err))
 		}
 	} else {
@@ -80,21 +370,96 @@ func (s *Server) deleteRelay(c echo.Context) error {
 
 	return c.JSON(http.StatusOK, map[string]interface{}{
 		"message": "ok",
+		"relay":   s.describeRecord(record),
+	})
+}
+
+// parseExpires reads an absolute time, a duration from now, or "never".
+func parseExpires(expires string, now time.Time) (time.Time, error) {
+	if expires == "never" {
+		return storage.NeverExpire, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, expires)
+	if err != nil {
+		d, durationErr := time.ParseDuration(expires)
+		if durationErr != nil {
+			return time.Time{}, fmt.Errorf("`expires` must be RFC 3339 time, duration or \"never\": %q", expires)
+		}
+		t = now.Add(d)
+	}
+	// such addresses would be removed right away, which DELETE /relay does instead
+	if !t.After(now) {
+		return time.Time{}, fmt.Errorf("`expires` must be in the future: %v", t)
+	}
+	return t, nil
+}
+
+func (s *Server) patchRelay(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	params := &PatchRelayRequest{}
+	if err := c.Bind(params); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("failed to parse request: %v", err))
+	}
+	if params.Expires == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("`expires` is required"))
+	}
+	expires, err := parseExpires(params.Expires, time.Now())
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if params.Strategy == "" {
+		params.Strategy = "default"
+	}
+
+	assigner, err := s.strategy(params.Strategy, params.Account)
+	if err != nil {
+		return err
+	}
+	updater, ok := assigner.(assign.ExpiryUpdater)
+	if !ok {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("strategy cannot update expiry: %v", params.Strategy))
+	}
+
+	var record *storage.Record
+
+	if params.URL != "" {
+		record, err = updater.UpdateExpiry(ctx, params.URL, expires)
+	} else if params.Address != "" {
+		record, err = updater.UpdateExpiryByAddr(ctx, params.Address, expires)
+	} else {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("either `url` or `address` is required"))
+	}
+	if err != nil {
+		if errors.Is(err, storage.ErrorUndefinedKey) || errors.Is(err, storage.ErrorUndefinedValue) {
+			return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("no address is assigned: %v", err))
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("failed to update expiry: %v", err))
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"message": "ok",
+		"relay":   s.describeRecord(record),
 	})
 }
 
 func (s *Server) deleteRelayExpired(c echo.Context) error {
 	ctx := c.Request().Context()
 
-	var tempAssigner *assign.TemporaryStrategy
+	// expired addresses of every strategy are removed at once, by any strategy which can
+	var expirer assign.Expirer
 	for _, assigner := range s.assigners {
 		var ok bool
-		if tempAssigner, ok = assigner.(*assign.TemporaryStrategy); ok {
+		if expirer, ok = assigner.(assign.Expirer); ok {
 			break
 		}
 	}
+	if expirer == nil {
+		return echo.NewHTTPError(http.StatusNotFound, "no strategy can unassign expired addresses")
+	}
 
-	count, err := tempAssigner.UnassignExpired(ctx, time.Now())
+	count, err := expirer.UnassignExpired(ctx, time.Now())
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("failed to unassign expired address: %v", err))
 	}
@@ -104,3 +469,26 @@ func (s *Server) deleteRelayExpired(c echo.Context) error {
 		"count":   count,
 	})
 }
+
+func (s *Server) postRelayReconcile(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	params := &PostRelayReconcileRequest{}
+	if err := c.Bind(params); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("failed to parse request: %v", err))
+	}
+
+	report, err := s.reconciler.Reconcile(ctx, params.DryRun, params.RemoveUnknown)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("failed to reconcile: %v", err))
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"message":         "ok",
+		"dry_run":         params.DryRun,
+		"orphaned_routes": report.OrphanedRoutes,
+		"unknown_routes":  report.UnknownRoutes,
+		"missing_routes":  report.MissingRoutes,
+		"repaired":        report.Repaired,
+	})
+}
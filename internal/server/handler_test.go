@@ -0,0 +1,168 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kaz/private-email-relay/internal/assign"
+	"github.com/kaz/private-email-relay/internal/router"
+	"github.com/kaz/private-email-relay/internal/storage"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseExpires(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	expires, err := parseExpires("never", now)
+	assert.NoError(t, err)
+	assert.True(t, storage.NeverExpire.Equal(expires))
+
+	expires, err = parseExpires("72h", now)
+	assert.NoError(t, err)
+	assert.True(t, now.Add(72*time.Hour).Equal(expires))
+
+	expires, err = parseExpires("2020-01-02T09:00:00+09:00", now)
+	assert.NoError(t, err)
+	assert.True(t, time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC).Equal(expires))
+
+	for _, invalid := range []string{"tomorrow", "-1h", "0s", "2019-12-31T00:00:00Z"} {
+		_, err := parseExpires(invalid, now)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestPatchRelay(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	route := router.NewMockRouter()
+
+	temporary, err := assign.New("temporary", store, route, assign.Params{})
+	if err != nil {
+		t.Skipf("skip temporary: %v", err)
+	}
+	burner, err := assign.New("burner", store, route, assign.Params{})
+	if err != nil {
+		t.Skipf("skip burner: %v", err)
+	}
+	s := &Server{
+		token:     "token",
+		store:     store,
+		assigners: map[string]assign.Strategy{"temporary": temporary, "burner": burner},
+	}
+	e := echo.New()
+	s.routes(e)
+
+	patch := func(body string) (int, *Relay) {
+		req := httptest.NewRequest(http.MethodPatch, "/relay", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set("Authorization", "Bearer token")
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+
+		resp := struct {
+			Relay *Relay `json:"relay"`
+		}{}
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		return rec.Code, resp.Relay
+	}
+
+	url := "https://testPatchRelay.test"
+	record, err := temporary.Assign(ctx, url, storage.Metadata{})
+	assert.NoError(t, err)
+
+	code, relay := patch(`{"strategy":"temporary","url":"` + url + `","expires":"never"}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Nil(t, relay.Expires)
+
+	code, relay = patch(`{"strategy":"temporary","address":"` + record.Value + `","expires":"1h"}`)
+	assert.Equal(t, http.StatusOK, code)
+	if assert.NotNil(t, relay.Expires) {
+		assert.WithinDuration(t, time.Now().Add(time.Hour), *relay.Expires, time.Minute)
+	}
+
+	code, _ = patch(`{"strategy":"temporary","url":"https://testPatchRelayUndefined.test","expires":"1h"}`)
+	assert.Equal(t, http.StatusNotFound, code)
+	code, _ = patch(`{"strategy":"temporary","url":"` + url + `","expires":"-1h"}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = patch(`{"strategy":"temporary","expires":"1h"}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	// addresses of other strategies are not found
+	burned, err := burner.Assign(ctx, "https://testPatchRelayBurner.test", storage.Metadata{})
+	assert.NoError(t, err)
+	code, _ = patch(`{"strategy":"temporary","address":"` + burned.Value + `","expires":"never"}`)
+	assert.Equal(t, http.StatusNotFound, code)
+}
+
+func TestDescribeRecord(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	route := router.NewMockRouter()
+
+	// all of them parse keys without namespace
+	s := &Server{store: store, assigners: map[string]assign.Strategy{}}
+	for _, name := range []string{"default", "custom", "plain"} {
+		strategy, err := assign.New("default", store, route, assign.Params{})
+		if err != nil {
+			t.Skipf("skip default: %v", err)
+		}
+		s.assigners[name] = strategy
+	}
+
+	record := &storage.Record{Key: "testdescriberecord.test", Value: "testDescribeRecord@test.test", Expires: storage.NeverExpire}
+	for i := 0; i < 10; i++ {
+		relay := s.describeRecord(record)
+		assert.Equal(t, "custom", relay.Strategy)
+		assert.Equal(t, "testdescriberecord.test", relay.Domain)
+	}
+}
+
+func TestGetRelaySite(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	route := router.NewMockRouter()
+
+	strategy, err := assign.New("default", store, route, assign.Params{})
+	if err != nil {
+		t.Skipf("skip default: %v", err)
+	}
+	s := &Server{
+		token:     "token",
+		store:     store,
+		assigners: map[string]assign.Strategy{"default": strategy},
+	}
+	e := echo.New()
+	s.routes(e)
+
+	url := "https://testgetrelaysite.test/login"
+	_, err = strategy.Assign(ctx, url, storage.Metadata{})
+	assert.NoError(t, err)
+	for _, account := range []string{"team", "test"} {
+		scoped, err := strategy.(assign.AccountScoped).ForAccount(account)
+		assert.NoError(t, err)
+		_, err = scoped.Assign(ctx, url, storage.Metadata{})
+		assert.NoError(t, err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/relay/site?url="+url+"&account=team&account=Team&account=none", nil)
+	req.Header.Set("Authorization", "Bearer token")
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	resp := struct {
+		Domain string   `json:"domain"`
+		Relays []*Relay `json:"relays"`
+	}{}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	assert.Equal(t, "testgetrelaysite.test", resp.Domain)
+
+	accounts := []string{}
+	for _, relay := range resp.Relays {
+		assert.Equal(t, "testgetrelaysite.test", relay.Domain)
+		accounts = append(accounts, relay.Account)
+	}
+	assert.Equal(t, []string{"", "team"}, accounts)
+}
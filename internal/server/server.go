@@ -4,9 +4,9 @@ import (
 	"context"
 	"fmt"
 	"os"
-	"time"
 
 	"github.com/kaz/private-email-relay/internal/assign"
+	"github.com/kaz/private-email-relay/internal/config"
 	"github.com/kaz/private-email-relay/internal/router"
 	"github.com/kaz/private-email-relay/internal/storage"
 	"github.com/labstack/echo/v4"
@@ -17,12 +17,18 @@ type (
 	Server struct {
 		bindAddr string
 		token    string
-
-		assigners map[string]assign.Strategy
+		// optional, to accept webhooks signed by Mailgun
+		webhookKey    string
+		webhookTokens webhookTokens
+
+		store      storage.Storage
+		assigners  map[string]assign.Strategy
+		reconciler *assign.Reconciler
+		importer   *assign.Importer
 	}
 )
 
-func New() (*Server, error) {
+func New(cfg *config.Config) (*Server, error) {
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "8080"
@@ -36,32 +42,51 @@ func New() (*Server, error) {
 	if server.token == "" {
 		return nil, fmt.Errorf("TOKEN is missing")
 	}
+	server.webhookKey = os.Getenv("MG_WEBHOOK_SIGNING_KEY")
 
-	var store storage.Storage
-	if fsStore, err := storage.NewFirestoreStorage(context.Background()); err == nil {
-		store = fsStore
-	} else {
-		fmt.Println("[[WARNING]] Using in-memory storage")
-		store = storage.NewMemoryStorage()
+	store, err := cfg.Storage.Open(context.Background())
+	if err != nil {
+		return nil, err
 	}
+	server.store = store
 
-	var route router.Router
-	if mgRoute, err := router.NewMailgunRouter(); err == nil {
-		route = mgRoute
-	} else {
-		return nil, fmt.Errorf("no router is available: %w", err)
+	route, err := router.Open(cfg.Router.Backend)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open router %v: %w", cfg.Router.Backend, err)
 	}
 
 	server.assigners = map[string]assign.Strategy{}
-	if defaultAssign, err := assign.NewDefaultStrategy(store, route); err == nil {
-		server.assigners["default"] = defaultAssign
+	strategies := []assign.Strategy{}
+	namespaces := map[string]string{}
+	for name, strategyCfg := range cfg.Strategies {
+		strategy, err := assign.New(strategyCfg.Type, store, route, strategyCfg.Params)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize strategy %v: %w", name, err)
+		}
+
+		// strategies sharing a namespace would take over keys of each other
+		if namespaced, ok := strategy.(interface{ Namespace() string }); ok {
+			if other, ok := namespaces[namespaced.Namespace()]; ok {
+				return nil, fmt.Errorf("strategy %v and %v have the same namespace: %q", name, other, namespaced.Namespace())
+			}
+			namespaces[namespaced.Namespace()] = name
+		}
+
+		server.assigners[name] = strategy
+		strategies = append(strategies, strategy)
 	}
-	if tempAssign, err := assign.NewTemporaryStrategy(store, route, func() time.Time { return time.Now().Add(3 * 24 * time.Hour) }); err == nil {
-		server.assigners["temporary"] = tempAssign
+
+	reconciler, err := assign.NewReconciler(store, route, strategies...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize reconciler: %w", err)
 	}
-	if len(server.assigners) == 0 {
-		return nil, fmt.Errorf("no strategy is available")
+	server.reconciler = reconciler
+
+	importer, err := assign.NewImporter(store, route, strategies...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize importer: %w", err)
 	}
+	server.importer = importer
 
 	return server, nil
 }
@@ -74,11 +99,23 @@ func (s *Server) Start(debug bool) error {
 	e.HideBanner = !debug
 
 	e.Use(middleware.Logger())
-	e.Use(s.authenticate)
-
-	e.POST("/relay", s.postRelay)
-	e.DELETE("/relay", s.deleteRelay)
-	e.DELETE("/relay/expired", s.deleteRelayExpired)
+	s.routes(e)
 
 	return e.Start(s.bindAddr)
 }
+
+func (s *Server) routes(e *echo.Echo) {
+	relay := e.Group("/relay", s.authenticate)
+	relay.GET("", s.getRelay)
+	relay.POST("", s.postRelay)
+	relay.PATCH("", s.patchRelay)
+	relay.DELETE("", s.deleteRelay)
+	relay.GET("/site", s.getRelaySite)
+	relay.DELETE("/expired", s.deleteRelayExpired)
+	relay.POST("/reconcile", s.postRelayReconcile)
+	relay.GET("/export", s.getRelayExport)
+	relay.POST("/import", s.postRelayImport)
+
+	// Mailgun cannot send TOKEN, but signs requests instead
+	e.POST("/webhook/delivery", s.postWebhookDelivery, s.authenticateWebhook)
+}
@@ -0,0 +1,135 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/kaz/private-email-relay/internal/assign"
+	"github.com/kaz/private-email-relay/internal/storage"
+	"github.com/labstack/echo/v4"
+)
+
+type (
+	// PostWebhookDeliveryRequest is a mail forwarded by a route of Mailgun, such as
+	// `forward("https://relay.example.com/webhook/delivery")`, of which only recipient is used.
+	PostWebhookDeliveryRequest struct {
+		Recipient string `json:"recipient" form:"recipient"`
+	}
+
+	// webhookTokens remembers tokens of signatures until they expire, so that each of them is accepted only once by an instance.
+	webhookTokens struct {
+		mu sync.Mutex
+		// token -> when its signature expires
+		expires map[string]time.Time
+	}
+)
+
+const (
+	// signatures older than this are rejected, and tokens of newer ones are remembered, not to be replayed
+	webhookSignatureLifetime = 5 * time.Minute
+)
+
+// authenticateWebhook accepts requests signed by Mailgun with MG_WEBHOOK_SIGNING_KEY, in addition to TOKEN.
+func (s *Server) authenticateWebhook(next echo.HandlerFunc) echo.HandlerFunc {
+	authenticated := s.authenticate(next)
+	return func(c echo.Context) error {
+		if s.webhookKey == "" || c.Request().Header.Get("Authorization") != "" {
+			return authenticated(c)
+		}
+		if !s.verifyWebhookSignature(c.FormValue("timestamp"), c.FormValue("token"), c.FormValue("signature")) {
+			return c.NoContent(http.StatusForbidden)
+		}
+		return next(c)
+	}
+}
+
+// remember reports whether token is seen for the first time, and forgets tokens which expired by now.
+func (t *webhookTokens) remember(token string, expires, now time.Time) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.expires == nil {
+		t.expires = map[string]time.Time{}
+	}
+	for seen, seenExpires := range t.expires {
+		if now.After(seenExpires) {
+			delete(t.expires, seen)
+		}
+	}
+
+	if _, ok := t.expires[token]; ok {
+		return false
+	}
+	t.expires[token] = expires
+	return true
+}
+
+// verifyWebhookSignature accepts each signature only once, not to be replayed while it is fresh.
+func (s *Server) verifyWebhookSignature(timestamp, token, signature string) bool {
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	signed := time.Unix(unix, 0)
+	if age := time.Since(signed); age > webhookSignatureLifetime || age < -webhookSignatureLifetime {
+		return false
+	}
+
+	expected, err := hex.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(s.webhookKey))
+	mac.Write([]byte(timestamp + token))
+	if !hmac.Equal(expected, mac.Sum(nil)) {
+		return false
+	}
+	return s.webhookTokens.remember(token, signed.Add(webhookSignatureLifetime), time.Now())
+}
+
+// postWebhookDelivery counts a mail delivered to an address, which is unassigned when it reached the limit.
+func (s *Server) postWebhookDelivery(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	params := &PostWebhookDeliveryRequest{}
+	if err := c.Bind(params); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("failed to parse request: %v", err))
+	}
+	if params.Recipient == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("`recipient` is required"))
+	}
+
+	// the limit is stored with each address, so that any strategy can count deliveries of every strategy
+	var counter assign.DeliveryCounter
+	for _, assigner := range s.assigners {
+		var ok bool
+		if counter, ok = assigner.(assign.DeliveryCounter); ok {
+			break
+		}
+	}
+	if counter == nil {
+		return echo.NewHTTPError(http.StatusNotFound, "no strategy can count deliveries")
+	}
+
+	record, unassigned, err := counter.CountDelivery(ctx, params.Recipient)
+	if err != nil {
+		if errors.Is(err, storage.ErrorUndefinedValue) {
+			// Mailgun stops retrying on 406
+			return echo.NewHTTPError(http.StatusNotAcceptable, fmt.Sprintf("no such address: %v", params.Recipient))
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("failed to count delivery: %v", err))
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"message":    "ok",
+		"unassigned": unassigned,
+		"relay":      s.describeRecord(record),
+	})
+}
@@ -0,0 +1,168 @@
+package server
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kaz/private-email-relay/internal/assign"
+	"github.com/kaz/private-email-relay/internal/router"
+	"github.com/kaz/private-email-relay/internal/storage"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	ctx = context.Background()
+)
+
+func newWebhookServer(t *testing.T) (*echo.Echo, assign.Strategy, router.Router) {
+	t.Helper()
+
+	store := storage.NewMemoryStorage()
+	route := router.NewMockRouter()
+
+	burner, err := assign.NewBurnerStrategy(store, route, 2)
+	if err != nil {
+		t.Skipf("skip burner: %v", err)
+	}
+
+	s := &Server{
+		token:      "token",
+		webhookKey: "signing-key",
+		store:      store,
+		assigners:  map[string]assign.Strategy{"burner": burner},
+	}
+	e := echo.New()
+	s.routes(e)
+	return e, burner, route
+}
+
+func sign(key, timestamp, token string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(timestamp + token))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// deliver posts a form as Mailgun forwards a mail
+func deliver(e *echo.Echo, form url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/webhook/delivery", strings.NewReader(form.Encode()))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+// signedDelivery signs with a new token every time, as Mailgun does
+func signedDelivery(recipient string) url.Values {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	token := "random-token-" + strconv.FormatInt(time.Now().UnixNano(), 10)
+	return url.Values{
+		"recipient": {recipient},
+		"subject":   {"Confirm your account"},
+		"timestamp": {timestamp},
+		"token":     {token},
+		"signature": {sign("signing-key", timestamp, token)},
+	}
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	e, burner, route := newWebhookServer(t)
+
+	record, err := burner.Assign(ctx, "https://testWebhookDelivery.test", storage.Metadata{})
+	assert.NoError(t, err)
+
+	rec := deliver(e, signedDelivery(record.Value))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	resp := struct {
+		Unassigned bool   `json:"unassigned"`
+		Relay      *Relay `json:"relay"`
+	}{}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	assert.False(t, resp.Unassigned)
+	assert.Equal(t, 1, resp.Relay.Deliveries)
+	assert.Equal(t, 2, resp.Relay.MaxDeliveries)
+	assert.Equal(t, "burner", resp.Relay.Strategy)
+
+	rec = deliver(e, signedDelivery(record.Value))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	assert.True(t, resp.Unassigned)
+	assert.Equal(t, 2, resp.Relay.Deliveries)
+
+	routed, err := route.Exists(ctx, record.Value)
+	assert.NoError(t, err)
+	assert.False(t, routed)
+
+	// already burned
+	rec = deliver(e, signedDelivery(record.Value))
+	assert.Equal(t, http.StatusNotAcceptable, rec.Code)
+}
+
+func TestWebhookDeliveryWithToken(t *testing.T) {
+	e, burner, _ := newWebhookServer(t)
+
+	record, err := burner.Assign(ctx, "https://testWebhookDeliveryWithToken.test", storage.Metadata{})
+	assert.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodPost, "/webhook/delivery", strings.NewReader(`{"recipient":"`+record.Value+`"}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Set("Authorization", "Bearer token")
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestWebhookDeliveryUnauthorized(t *testing.T) {
+	e, burner, _ := newWebhookServer(t)
+
+	record, err := burner.Assign(ctx, "https://testWebhookDeliveryUnauthorized.test", storage.Metadata{})
+	assert.NoError(t, err)
+
+	forged := signedDelivery(record.Value)
+	forged.Set("signature", sign("wrong-key", forged.Get("timestamp"), forged.Get("token")))
+	assert.Equal(t, http.StatusForbidden, deliver(e, forged).Code)
+
+	stale := signedDelivery(record.Value)
+	timestamp := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+	stale.Set("timestamp", timestamp)
+	stale.Set("signature", sign("signing-key", timestamp, stale.Get("token")))
+	assert.Equal(t, http.StatusForbidden, deliver(e, stale).Code)
+
+	unsigned := url.Values{"recipient": {record.Value}}
+	assert.Equal(t, http.StatusForbidden, deliver(e, unsigned).Code)
+
+	// nothing is counted
+	got, err := burner.Lookup(ctx, "https://testWebhookDeliveryUnauthorized.test")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, got.Deliveries)
+}
+
+func TestWebhookDeliveryReplayed(t *testing.T) {
+	e, burner, route := newWebhookServer(t)
+
+	record, err := burner.Assign(ctx, "https://testWebhookDeliveryReplayed.test", storage.Metadata{})
+	assert.NoError(t, err)
+
+	signed := signedDelivery(record.Value)
+	assert.Equal(t, http.StatusOK, deliver(e, signed).Code)
+	assert.Equal(t, http.StatusForbidden, deliver(e, signed).Code)
+
+	// replays are not counted, so that the address is not burned early
+	routed, err := route.Exists(ctx, record.Value)
+	assert.NoError(t, err)
+	assert.True(t, routed)
+	got, err := burner.Lookup(ctx, "https://testWebhookDeliveryReplayed.test")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, got.Deliveries)
+}
@@ -0,0 +1,294 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+type (
+	BoltStorage struct {
+		db *bolt.DB
+	}
+)
+
+var (
+	// key -> record
+	boltRecordsBucket = []byte("records")
+	// address -> key
+	boltAddressesBucket = []byte("addresses")
+	// expires + key -> (empty)
+	boltExpiresBucket = []byte("expires")
+)
+
+func NewBoltStorage(ctx context.Context) (Storage, error) {
+	path := os.Getenv("BOLT_PATH")
+	if path == "" {
+		return nil, fmt.Errorf("BOLT_PATH is missing")
+	}
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 3 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open bbolt database: %w", err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{boltRecordsBucket, boltAddressesBucket, boltExpiresBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return fmt.Errorf("failed to create bucket %s: %w", name, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &BoltStorage{db}, nil
+}
+
+// expiry index is ordered by expires, and then by key
+func boltExpiresKey(expires time.Time, key string) []byte {
+	buf := make([]byte, 8, 8+len(key))
+	// flip the sign bit so that negative timestamps come first
+	binary.BigEndian.PutUint64(buf, uint64(toMicros(expires))^(1<<63))
+	return append(buf, key...)
+}
+
+func (s *BoltStorage) get(tx *bolt.Tx, key string) (*Record, error) {
+	encoded := tx.Bucket(boltRecordsBucket).Get([]byte(key))
+	if encoded == nil {
+		return nil, fmt.Errorf("%w: key=%v", ErrorUndefinedKey, key)
+	}
+	return decodeJSONRecord(key, encoded)
+}
+
+func (s *BoltStorage) unset(tx *bolt.Tx, key string) (*Record, error) {
+	record, err := s.get(tx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Bucket(boltRecordsBucket).Delete([]byte(key)); err != nil {
+		return nil, fmt.Errorf("failed to delete record: %w", err)
+	}
+	if err := tx.Bucket(boltAddressesBucket).Delete([]byte(record.Value)); err != nil {
+		return nil, fmt.Errorf("failed to delete address index: %w", err)
+	}
+	if err := tx.Bucket(boltExpiresBucket).Delete(boltExpiresKey(record.Expires, key)); err != nil {
+		return nil, fmt.Errorf("failed to delete expiry index: %w", err)
+	}
+	return record, nil
+}
+
+func (s *BoltStorage) Get(ctx context.Context, key string) (*Record, error) {
+	var record *Record
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		record, err = s.get(tx, key)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+func (s *BoltStorage) GetByValue(ctx context.Context, value string) (*Record, error) {
+	var record *Record
+	err := s.db.View(func(tx *bolt.Tx) error {
+		key := tx.Bucket(boltAddressesBucket).Get([]byte(value))
+		if key == nil {
+			return fmt.Errorf("%w: value=%v", ErrorUndefinedValue, value)
+		}
+
+		var err error
+		record, err = s.get(tx, string(key))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+func (s *BoltStorage) Set(ctx context.Context, record *Record) error {
+	encoded, err := encodeJSONRecord(record)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		records := tx.Bucket(boltRecordsBucket)
+		addresses := tx.Bucket(boltAddressesBucket)
+
+		if records.Get([]byte(record.Key)) != nil {
+			return fmt.Errorf("%w: key=%v", ErrorDuplicatedKey, record.Key)
+		}
+		if addresses.Get([]byte(record.Value)) != nil {
+			return fmt.Errorf("%w: value=%v", ErrorDuplicatedValue, record.Value)
+		}
+
+		if err := records.Put([]byte(record.Key), encoded); err != nil {
+			return fmt.Errorf("failed to put record: %w", err)
+		}
+		if err := addresses.Put([]byte(record.Value), []byte(record.Key)); err != nil {
+			return fmt.Errorf("failed to put address index: %w", err)
+		}
+		if err := tx.Bucket(boltExpiresBucket).Put(boltExpiresKey(record.Expires, record.Key), []byte{}); err != nil {
+			return fmt.Errorf("failed to put expiry index: %w", err)
+		}
+		return nil
+	})
+}
+
+func (s *BoltStorage) UnsetByKey(ctx context.Context, key string) (*Record, error) {
+	var record *Record
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		record, err = s.unset(tx, key)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+func (s *BoltStorage) UnsetByValue(ctx context.Context, value string) (*Record, error) {
+	var record *Record
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		key := tx.Bucket(boltAddressesBucket).Get([]byte(value))
+		if key == nil {
+			return fmt.Errorf("%w: value=%v", ErrorUndefinedValue, value)
+		}
+
+		var err error
+		record, err = s.unset(tx, string(key))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// update rewrites the record of value by fn, and the expiry index as well.
+func (s *BoltStorage) update(value string, fn func(record *Record)) (*Record, error) {
+	var record *Record
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		key := tx.Bucket(boltAddressesBucket).Get([]byte(value))
+		if key == nil {
+			return fmt.Errorf("%w: value=%v", ErrorUndefinedValue, value)
+		}
+
+		var err error
+		record, err = s.get(tx, string(key))
+		if err != nil {
+			return err
+		}
+		expires := tx.Bucket(boltExpiresBucket)
+		if err := expires.Delete(boltExpiresKey(record.Expires, record.Key)); err != nil {
+			return fmt.Errorf("failed to delete expiry index: %w", err)
+		}
+
+		fn(record)
+
+		encoded, err := encodeJSONRecord(record)
+		if err != nil {
+			return err
+		}
+		if err := tx.Bucket(boltRecordsBucket).Put(key, encoded); err != nil {
+			return fmt.Errorf("failed to put record: %w", err)
+		}
+		if err := expires.Put(boltExpiresKey(record.Expires, record.Key), []byte{}); err != nil {
+			return fmt.Errorf("failed to put expiry index: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+func (s *BoltStorage) CountDelivery(ctx context.Context, value string) (*Record, error) {
+	return s.update(value, func(record *Record) {
+		record.Deliveries++
+		record.UpdatedAt = time.Now()
+	})
+}
+
+func (s *BoltStorage) UpdateExpires(ctx context.Context, value string, expires time.Time) (*Record, error) {
+	return s.update(value, func(record *Record) {
+		record.Expires = expires
+		record.UpdatedAt = time.Now()
+	})
+}
+
+func (s *BoltStorage) UnsetExpired(ctx context.Context, until time.Time) ([]string, error) {
+	valuesExpired := []string{}
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		// collect first, since deleting while iterating skips entries
+		bound := boltExpiresKey(until, "")
+		keys := []string{}
+
+		c := tx.Bucket(boltExpiresBucket).Cursor()
+		for k, _ := c.First(); k != nil && bytes.Compare(k, bound) < 0; k, _ = c.Next() {
+			keys = append(keys, string(k[8:]))
+		}
+
+		for _, key := range keys {
+			record, err := s.unset(tx, key)
+			if err != nil {
+				return err
+			}
+			valuesExpired = append(valuesExpired, record.Value)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return valuesExpired, nil
+}
+
+func (s *BoltStorage) List(ctx context.Context, cursor string, limit int) ([]*Record, string, error) {
+	if err := validateLimit(limit); err != nil {
+		return nil, "", err
+	}
+
+	records := []*Record{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(boltRecordsBucket).Cursor()
+
+		k, v := c.Seek([]byte(cursor))
+		if k != nil && string(k) == cursor {
+			k, v = c.Next()
+		}
+		for ; k != nil && len(records) <= limit; k, v = c.Next() {
+			record, err := decodeJSONRecord(string(k), v)
+			if err != nil {
+				return err
+			}
+			records = append(records, record)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	nextCursor := ""
+	if len(records) > limit {
+		records = records[:limit]
+		nextCursor = records[limit-1].Key
+	}
+	return records, nextCursor, nil
+}
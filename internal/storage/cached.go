@@ -0,0 +1,182 @@
+package storage
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type (
+	// CachedStorage wraps another storage with an LRU cache for Get.
+	// Entries are invalidated by writes through this storage, and by TTL for writes by others.
+	CachedStorage struct {
+		inner Storage
+		size  int
+		ttl   time.Duration
+
+		entries map[string]*list.Element
+		// value -> key, to invalidate entries by UnsetExpired
+		values map[string]string
+		// most recently used first
+		lru *list.List
+		// incremented on each invalidation, so that Get does not cache a record read before it
+		generation uint64
+		mu         sync.Mutex
+
+		hits   uint64
+		misses uint64
+	}
+
+	cacheEntry struct {
+		record  *Record
+		expires time.Time
+	}
+
+	CacheStats struct {
+		Hits   uint64
+		Misses uint64
+	}
+)
+
+func NewCachedStorage(inner Storage, size int, ttl time.Duration) *CachedStorage {
+	return &CachedStorage{
+		inner:   inner,
+		size:    size,
+		ttl:     ttl,
+		entries: map[string]*list.Element{},
+		values:  map[string]string{},
+		lru:     list.New(),
+	}
+}
+
+func (s *CachedStorage) Stats() CacheStats {
+	return CacheStats{
+		Hits:   atomic.LoadUint64(&s.hits),
+		Misses: atomic.LoadUint64(&s.misses),
+	}
+}
+
+func (s *CachedStorage) lookup(key string) (*Record, uint64, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	elem, ok := s.entries[key]
+	if !ok {
+		return nil, s.generation, false
+	}
+
+	entry := elem.Value.(*cacheEntry)
+	if time.Now().After(entry.expires) {
+		s.remove(elem)
+		return nil, s.generation, false
+	}
+
+	s.lru.MoveToFront(elem)
+	return entry.record.clone(), s.generation, true
+}
+
+func (s *CachedStorage) store(record *Record, generation uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// invalidated while reading, and the record may be stale
+	if s.generation != generation {
+		return
+	}
+	if elem, ok := s.entries[record.Key]; ok {
+		s.remove(elem)
+	}
+
+	s.entries[record.Key] = s.lru.PushFront(&cacheEntry{record.clone(), time.Now().Add(s.ttl)})
+	s.values[record.Value] = record.Key
+
+	for s.lru.Len() > s.size {
+		s.remove(s.lru.Back())
+	}
+}
+
+func (s *CachedStorage) remove(elem *list.Element) {
+	record := s.lru.Remove(elem).(*cacheEntry).record
+	delete(s.entries, record.Key)
+	delete(s.values, record.Value)
+}
+
+func (s *CachedStorage) invalidate(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.generation++
+	if elem, ok := s.entries[key]; ok {
+		s.remove(elem)
+	}
+}
+func (s *CachedStorage) invalidateByValue(value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.generation++
+	if key, ok := s.values[value]; ok {
+		s.remove(s.entries[key])
+	}
+}
+
+func (s *CachedStorage) Get(ctx context.Context, key string) (*Record, error) {
+	record, generation, ok := s.lookup(key)
+	if ok {
+		atomic.AddUint64(&s.hits, 1)
+		return record, nil
+	}
+	atomic.AddUint64(&s.misses, 1)
+
+	record, err := s.inner.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	s.store(record, generation)
+	return record, nil
+}
+
+// records are not cached by value, which is looked up rarely
+func (s *CachedStorage) GetByValue(ctx context.Context, value string) (*Record, error) {
+	return s.inner.GetByValue(ctx, value)
+}
+
+func (s *CachedStorage) Set(ctx context.Context, record *Record) error {
+	defer s.invalidate(record.Key)
+	return s.inner.Set(ctx, record)
+}
+
+func (s *CachedStorage) UnsetByKey(ctx context.Context, key string) (*Record, error) {
+	defer s.invalidate(key)
+	return s.inner.UnsetByKey(ctx, key)
+}
+
+func (s *CachedStorage) UnsetByValue(ctx context.Context, value string) (*Record, error) {
+	// the key is not known until deleted
+	defer s.invalidateByValue(value)
+	return s.inner.UnsetByValue(ctx, value)
+}
+
+func (s *CachedStorage) CountDelivery(ctx context.Context, value string) (*Record, error) {
+	defer s.invalidateByValue(value)
+	return s.inner.CountDelivery(ctx, value)
+}
+
+func (s *CachedStorage) UpdateExpires(ctx context.Context, value string, expires time.Time) (*Record, error) {
+	defer s.invalidateByValue(value)
+	return s.inner.UpdateExpires(ctx, value, expires)
+}
+
+func (s *CachedStorage) UnsetExpired(ctx context.Context, until time.Time) ([]string, error) {
+	valuesExpired, err := s.inner.UnsetExpired(ctx, until)
+	for _, value := range valuesExpired {
+		s.invalidateByValue(value)
+	}
+	return valuesExpired, err
+}
+
+func (s *CachedStorage) List(ctx context.Context, cursor string, limit int) ([]*Record, string, error) {
+	return s.inner.List(ctx, cursor, limit)
+}
@@ -0,0 +1,250 @@
+package storage_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kaz/private-email-relay/internal/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCachedHitAndMiss(t *testing.T) {
+	inner := storage.NewMemoryStorage()
+	s := storage.NewCachedStorage(inner, 10, time.Minute)
+	key := "testCachedHitAndMiss.test"
+
+	err := s.Set(ctx, &storage.Record{Key: key, Value: "testCachedHitAndMiss-0@test.test", Expires: storage.NeverExpire})
+	assert.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		got, err := s.Get(ctx, key)
+		assert.NoError(t, err)
+		assert.Equal(t, "testCachedHitAndMiss-0@test.test", got.Value)
+	}
+	assert.Equal(t, storage.CacheStats{Hits: 2, Misses: 1}, s.Stats())
+
+	// invalidated by writes
+	_, err = s.UnsetByValue(ctx, "testCachedHitAndMiss-0@test.test")
+	assert.NoError(t, err)
+	err = s.Set(ctx, &storage.Record{Key: key, Value: "testCachedHitAndMiss-1@test.test", Expires: storage.NeverExpire})
+	assert.NoError(t, err)
+
+	got, err := s.Get(ctx, key)
+	assert.NoError(t, err)
+	assert.Equal(t, "testCachedHitAndMiss-1@test.test", got.Value)
+	assert.Equal(t, storage.CacheStats{Hits: 2, Misses: 2}, s.Stats())
+
+	// undefined keys are not cached
+	for i := 0; i < 2; i++ {
+		_, err = s.Get(ctx, "testCachedHitAndMiss-undefined.test")
+		assert.True(t, errors.Is(err, storage.ErrorUndefinedKey))
+	}
+	assert.Equal(t, storage.CacheStats{Hits: 2, Misses: 4}, s.Stats())
+}
+
+func TestCachedTTL(t *testing.T) {
+	inner := storage.NewMemoryStorage()
+	s := storage.NewCachedStorage(inner, 10, 50*time.Millisecond)
+	key := "testCachedTTL.test"
+
+	err := s.Set(ctx, &storage.Record{Key: key, Value: "testCachedTTL@test.test", Expires: storage.NeverExpire})
+	assert.NoError(t, err)
+	_, err = s.Get(ctx, key)
+	assert.NoError(t, err)
+
+	// written by another instance
+	_, err = inner.UnsetByKey(ctx, key)
+	assert.NoError(t, err)
+
+	_, err = s.Get(ctx, key)
+	assert.NoError(t, err)
+
+	time.Sleep(100 * time.Millisecond)
+
+	_, err = s.Get(ctx, key)
+	assert.True(t, errors.Is(err, storage.ErrorUndefinedKey))
+}
+
+func TestCachedEviction(t *testing.T) {
+	inner := storage.NewMemoryStorage()
+	s := storage.NewCachedStorage(inner, 2, time.Minute)
+
+	for i := 0; i < 3; i++ {
+		err := s.Set(ctx, &storage.Record{Key: fmt.Sprintf("testCachedEviction-%d.test", i), Value: fmt.Sprintf("testCachedEviction-%d@test.test", i), Expires: storage.NeverExpire})
+		assert.NoError(t, err)
+	}
+
+	// 0 is evicted as least recently used
+	for _, i := range []int{0, 1, 2, 1, 2, 0} {
+		_, err := s.Get(ctx, fmt.Sprintf("testCachedEviction-%d.test", i))
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, storage.CacheStats{Hits: 2, Misses: 4}, s.Stats())
+}
+
+func TestCachedUnsetExpired(t *testing.T) {
+	inner := storage.NewMemoryStorage()
+	s := storage.NewCachedStorage(inner, 10, time.Minute)
+	key := "testCachedUnsetExpired.test"
+
+	err := s.Set(ctx, &storage.Record{Key: key, Value: "testCachedUnsetExpired@test.test", Expires: time.Now().Add(-time.Hour)})
+	assert.NoError(t, err)
+	_, err = s.Get(ctx, key)
+	assert.NoError(t, err)
+
+	deleted, err := s.UnsetExpired(ctx, time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"testCachedUnsetExpired@test.test"}, deleted)
+
+	_, err = s.Get(ctx, key)
+	assert.True(t, errors.Is(err, storage.ErrorUndefinedKey))
+}
+
+type (
+	// pausedGetStorage stops Get after reading a record until released
+	pausedGetStorage struct {
+		storage.Storage
+		read    chan struct{}
+		release chan struct{}
+	}
+)
+
+func (s *pausedGetStorage) Get(ctx context.Context, key string) (*storage.Record, error) {
+	record, err := s.Storage.Get(ctx, key)
+	s.read <- struct{}{}
+	<-s.release
+	return record, err
+}
+
+func TestCachedStaleRead(t *testing.T) {
+	inner := &pausedGetStorage{storage.NewMemoryStorage(), make(chan struct{}), make(chan struct{})}
+	s := storage.NewCachedStorage(inner, 10, time.Minute)
+	key := "testCachedStaleRead.test"
+
+	err := inner.Storage.Set(ctx, &storage.Record{Key: key, Value: "testCachedStaleRead@test.test", Expires: storage.NeverExpire})
+	assert.NoError(t, err)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		got, err := s.Get(ctx, key)
+		if assert.NoError(t, err) {
+			assert.Equal(t, "testCachedStaleRead@test.test", got.Value)
+		}
+	}()
+
+	// deleted after the record is read, but before it is cached
+	<-inner.read
+	_, err = s.UnsetByKey(ctx, key)
+	assert.NoError(t, err)
+	inner.release <- struct{}{}
+	<-done
+
+	go func() {
+		<-inner.read
+		inner.release <- struct{}{}
+	}()
+	_, err = s.Get(ctx, key)
+	assert.True(t, errors.Is(err, storage.ErrorUndefinedKey))
+}
+
+// every read through the cache must agree with the underlying storage, once writes are done
+func TestCachedConsistency(t *testing.T) {
+	inner := storage.NewMemoryStorage()
+	s := storage.NewCachedStorage(inner, 8, time.Minute)
+
+	keys := 16
+	workers := 8
+	operations := 2000
+
+	wg := sync.WaitGroup{}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			random := rand.New(rand.NewSource(int64(w)))
+
+			for i := 0; i < operations; i++ {
+				n := random.Intn(keys)
+				key := fmt.Sprintf("testCachedConsistency-%d.test", n)
+				value := fmt.Sprintf("testCachedConsistency-%d-%d-%d@test.test", n, w, i)
+
+				switch random.Intn(4) {
+				case 0:
+					s.Set(ctx, &storage.Record{Key: key, Value: value, Expires: storage.NeverExpire})
+				case 1:
+					s.UnsetByKey(ctx, key)
+				case 2:
+					if record, err := s.Get(ctx, key); err == nil {
+						s.UnsetByValue(ctx, record.Value)
+					}
+				default:
+					s.Get(ctx, key)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	for n := 0; n < keys; n++ {
+		key := fmt.Sprintf("testCachedConsistency-%d.test", n)
+
+		expected, expectedErr := inner.Get(ctx, key)
+		actual, actualErr := s.Get(ctx, key)
+		if expectedErr != nil {
+			assert.True(t, errors.Is(actualErr, storage.ErrorUndefinedKey), key)
+		} else if assert.NoError(t, actualErr, key) {
+			assert.Equal(t, expected.Value, actual.Value, key)
+		}
+	}
+
+	stats := s.Stats()
+	assert.Greater(t, stats.Hits, uint64(0))
+	assert.Greater(t, stats.Misses, uint64(0))
+}
+
+// a worker owning its keys always reads its own writes, while others write concurrently
+func TestCachedReadYourWrites(t *testing.T) {
+	inner := storage.NewMemoryStorage()
+	s := storage.NewCachedStorage(inner, 8, time.Minute)
+
+	workers := 8
+	operations := 500
+
+	wg := sync.WaitGroup{}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			key := fmt.Sprintf("testCachedReadYourWrites-%d.test", w)
+
+			for i := 0; i < operations; i++ {
+				value := fmt.Sprintf("testCachedReadYourWrites-%d-%d@test.test", w, i)
+
+				err := s.Set(ctx, &storage.Record{Key: key, Value: value, Expires: storage.NeverExpire})
+				assert.NoError(t, err)
+
+				got, err := s.Get(ctx, key)
+				if assert.NoError(t, err) {
+					assert.Equal(t, value, got.Value)
+				}
+
+				if i%2 == 0 {
+					_, err = s.UnsetByKey(ctx, key)
+				} else {
+					_, err = s.UnsetByValue(ctx, value)
+				}
+				assert.NoError(t, err)
+
+				_, err = s.Get(ctx, key)
+				assert.True(t, errors.Is(err, storage.ErrorUndefinedKey))
+			}
+		}(w)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,232 @@
+package storage
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+type (
+	// EncryptedStorage wraps another storage so that site keys and metadata are not readable at rest.
+	// Keys are replaced by their HMAC, and the original key and metadata are sealed into Note with AES-GCM.
+	// Addresses and expiry are kept in plain, so that lookup by address and UnsetExpired still work.
+	EncryptedStorage struct {
+		inner   Storage
+		hmacKey []byte
+		aead    cipher.AEAD
+	}
+
+	encryptedEnvelope struct {
+		Key   string   `json:"key"`
+		Label string   `json:"label,omitempty"`
+		Note  string   `json:"note,omitempty"`
+		Tags  []string `json:"tags,omitempty"`
+	}
+)
+
+const (
+	encryptionKeySize = 32
+)
+
+// EncryptionConfigured reports whether the key of NewEncryptedStorage is set.
+func EncryptionConfigured() bool {
+	return os.Getenv("STORAGE_ENCRYPTION_KEY") != "" || os.Getenv("STORAGE_ENCRYPTION_KEY_FILE") != ""
+}
+
+// NewEncryptedStorage reads a base64-encoded 32 bytes key from STORAGE_ENCRYPTION_KEY, or from the file at STORAGE_ENCRYPTION_KEY_FILE.
+func NewEncryptedStorage(inner Storage) (Storage, error) {
+	encoded := os.Getenv("STORAGE_ENCRYPTION_KEY")
+	if path := os.Getenv("STORAGE_ENCRYPTION_KEY_FILE"); encoded == "" && path != "" {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read STORAGE_ENCRYPTION_KEY_FILE: %w", err)
+		}
+		encoded = strings.TrimSpace(string(content))
+	}
+	if encoded == "" {
+		return nil, fmt.Errorf("STORAGE_ENCRYPTION_KEY is missing")
+	}
+
+	master, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode encryption key: %w", err)
+	}
+	if len(master) != encryptionKeySize {
+		return nil, fmt.Errorf("encryption key must be %d bytes, but %d bytes", encryptionKeySize, len(master))
+	}
+
+	block, err := aes.NewCipher(deriveKey(master, "aes-gcm"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+
+	return &EncryptedStorage{
+		inner:   inner,
+		hmacKey: deriveKey(master, "hmac-sha256"),
+		aead:    aead,
+	}, nil
+}
+
+// separate keys are derived for each purpose, not to use one key for both of them
+func deriveKey(master []byte, purpose string) []byte {
+	mac := hmac.New(sha256.New, master)
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
+
+func (s *EncryptedStorage) hashKey(key string) string {
+	mac := hmac.New(sha256.New, s.hmacKey)
+	mac.Write([]byte(key))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (s *EncryptedStorage) seal(record *Record) (*Record, error) {
+	plaintext, err := json.Marshal(&encryptedEnvelope{
+		Key:   record.Key,
+		Label: record.Label,
+		Note:  record.Note,
+		Tags:  record.Tags,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode envelope: %w", err)
+	}
+
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	hashedKey := s.hashKey(record.Key)
+	// the envelope is bound to its key, not to be swapped with another one
+	sealed := s.aead.Seal(nonce, nonce, plaintext, []byte(hashedKey))
+
+	return &Record{
+		Key:           hashedKey,
+		Value:         record.Value,
+		Expires:       record.Expires,
+		Deliveries:    record.Deliveries,
+		MaxDeliveries: record.MaxDeliveries,
+		Metadata:      Metadata{Note: base64.StdEncoding.EncodeToString(sealed)},
+		CreatedAt:     record.CreatedAt,
+		UpdatedAt:     record.UpdatedAt,
+	}, nil
+}
+func (s *EncryptedStorage) open(record *Record) (*Record, error) {
+	sealed, err := base64.StdEncoding.DecodeString(record.Note)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode envelope: key=%v: %w", record.Key, err)
+	}
+	if len(sealed) < s.aead.NonceSize() {
+		return nil, fmt.Errorf("envelope is too short: key=%v", record.Key)
+	}
+
+	nonce, ciphertext := sealed[:s.aead.NonceSize()], sealed[s.aead.NonceSize():]
+	plaintext, err := s.aead.Open(nil, nonce, ciphertext, []byte(record.Key))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt envelope: key=%v: %w", record.Key, err)
+	}
+
+	envelope := &encryptedEnvelope{}
+	if err := json.Unmarshal(plaintext, envelope); err != nil {
+		return nil, fmt.Errorf("failed to decode envelope: key=%v: %w", record.Key, err)
+	}
+
+	return &Record{
+		Key:           envelope.Key,
+		Value:         record.Value,
+		Expires:       record.Expires,
+		Deliveries:    record.Deliveries,
+		MaxDeliveries: record.MaxDeliveries,
+		Metadata: Metadata{
+			Label: envelope.Label,
+			Note:  envelope.Note,
+			Tags:  envelope.Tags,
+		},
+		CreatedAt: record.CreatedAt,
+		UpdatedAt: record.UpdatedAt,
+	}, nil
+}
+func (s *EncryptedStorage) openOrError(record *Record, err error) (*Record, error) {
+	if err != nil {
+		return nil, err
+	}
+	return s.open(record)
+}
+
+func (s *EncryptedStorage) Get(ctx context.Context, key string) (*Record, error) {
+	return s.openOrError(s.inner.Get(ctx, s.hashKey(key)))
+}
+
+func (s *EncryptedStorage) GetByValue(ctx context.Context, value string) (*Record, error) {
+	return s.openOrError(s.inner.GetByValue(ctx, value))
+}
+
+func (s *EncryptedStorage) Set(ctx context.Context, record *Record) error {
+	sealed, err := s.seal(record)
+	if err != nil {
+		return err
+	}
+	return s.inner.Set(ctx, sealed)
+}
+
+func (s *EncryptedStorage) UnsetByKey(ctx context.Context, key string) (*Record, error) {
+	return s.openOrError(s.inner.UnsetByKey(ctx, s.hashKey(key)))
+}
+
+func (s *EncryptedStorage) UnsetByValue(ctx context.Context, value string) (*Record, error) {
+	return s.openOrError(s.inner.UnsetByValue(ctx, value))
+}
+
+func (s *EncryptedStorage) CountDelivery(ctx context.Context, value string) (*Record, error) {
+	return s.openOrError(s.inner.CountDelivery(ctx, value))
+}
+
+func (s *EncryptedStorage) UpdateExpires(ctx context.Context, value string, expires time.Time) (*Record, error) {
+	return s.openOrError(s.inner.UpdateExpires(ctx, value, expires))
+}
+
+func (s *EncryptedStorage) UnsetExpired(ctx context.Context, until time.Time) ([]string, error) {
+	return s.inner.UnsetExpired(ctx, until)
+}
+
+// records are listed in order of HMAC of key, instead of key itself.
+// cursor is still the plain key of the last record, so that pagination works as usual.
+func (s *EncryptedStorage) List(ctx context.Context, cursor string, limit int) ([]*Record, string, error) {
+	if cursor != "" {
+		cursor = s.hashKey(cursor)
+	}
+
+	sealeds, nextCursor, err := s.inner.List(ctx, cursor, limit)
+	if err != nil {
+		return nil, "", err
+	}
+
+	records := make([]*Record, 0, len(sealeds))
+	for _, sealed := range sealeds {
+		record, err := s.open(sealed)
+		if err != nil {
+			return nil, "", err
+		}
+		records = append(records, record)
+	}
+
+	if nextCursor != "" && len(records) > 0 {
+		nextCursor = records[len(records)-1].Key
+	}
+	return records, nextCursor, nil
+}
@@ -0,0 +1,149 @@
+package storage_test
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kaz/private-email-relay/internal/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+func setenv(t *testing.T, key, value string) {
+	prev, ok := os.LookupEnv(key)
+	os.Setenv(key, value)
+	t.Cleanup(func() {
+		if ok {
+			os.Setenv(key, prev)
+		} else {
+			os.Unsetenv(key)
+		}
+	})
+}
+
+func newEncryptedStorage(t *testing.T) (storage.Storage, storage.Storage) {
+	t.Helper()
+
+	key := base64.StdEncoding.EncodeToString([]byte("0123456789abcdef0123456789abcdef"))
+	setenv(t, "STORAGE_ENCRYPTION_KEY", key)
+
+	inner := storage.NewMemoryStorage()
+	s, err := storage.NewEncryptedStorage(inner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, inner
+}
+
+// List is not included, since encrypted storage is ordered by HMAC of key
+func TestEncrypted(t *testing.T) {
+	s, _ := newEncryptedStorage(t)
+
+	for name, test := range map[string]func(*testing.T, storage.Storage){
+		"SetAndGet":           testSetAndGet,
+		"GetByValue":          testGetByValue,
+		"SetAndGetMetadata":   testSetAndGetMetadata,
+		"UnsetByKey":          testUnsetByKey,
+		"UnsetByValue":        testUnsetByValue,
+		"UnsetExpired":        testUnsetExpired,
+		"GetUndefinedKey":     testGetUndefinedKey,
+		"SetDuplicatedKey":    testSetDuplicatedKey,
+		"SetDuplicatedValue":  testSetDuplicatedValue,
+		"UnsetUndefinedKey":   testUnsetUndefinedKey,
+		"UnsetUndefinedValue": testUnsetUndefinedValue,
+		"CountDelivery":       testCountDelivery,
+		"UpdateExpires":       testUpdateExpires,
+		"ListInvalidLimit":    testListInvalidLimit,
+		"SetConcurrently":     testSetConcurrently,
+		"UnsetConcurrently":   testUnsetConcurrently,
+	} {
+		t.Run(name, func(t *testing.T) {
+			test(t, s)
+		})
+	}
+}
+
+func TestEncryptedAtRest(t *testing.T) {
+	s, inner := newEncryptedStorage(t)
+
+	record := &storage.Record{
+		Key:     "testEncryptedAtRest.test",
+		Value:   "testEncryptedAtRest@test.test",
+		Expires: storage.NeverExpire,
+		Metadata: storage.Metadata{
+			Label: "secret label",
+			Note:  "secret note",
+			Tags:  []string{"secret tag"},
+		},
+	}
+	err := s.Set(ctx, record)
+	assert.NoError(t, err)
+
+	stored, _, err := inner.List(ctx, "", 10)
+	assert.NoError(t, err)
+	if assert.Len(t, stored, 1) {
+		assert.NotContains(t, stored[0].Key, "testEncryptedAtRest")
+		assert.Equal(t, record.Value, stored[0].Value)
+		assert.Empty(t, stored[0].Label)
+		assert.Empty(t, stored[0].Tags)
+		assert.NotContains(t, stored[0].Note, "secret")
+	}
+
+	got, err := s.Get(ctx, record.Key)
+	assert.NoError(t, err)
+	assert.Equal(t, record.Metadata, got.Metadata)
+
+	// sealed envelope cannot be moved to another key
+	_, err = inner.UnsetByKey(ctx, stored[0].Key)
+	assert.NoError(t, err)
+	stored[0].Key = strings.Repeat("0", 64)
+	err = inner.Set(ctx, stored[0])
+	assert.NoError(t, err)
+
+	_, _, err = s.List(ctx, "", 10)
+	assert.Error(t, err)
+}
+
+func TestEncryptedList(t *testing.T) {
+	s, _ := newEncryptedStorage(t)
+
+	expected := map[string]bool{}
+	for i := 0; i < 7; i++ {
+		key := fmt.Sprintf("testEncryptedList-%d.test", i)
+		err := s.Set(ctx, &storage.Record{Key: key, Value: fmt.Sprintf("testEncryptedList-%d@test.test", i), Expires: time.Now().Add(time.Hour)})
+		assert.NoError(t, err)
+		expected[key] = true
+	}
+
+	listed := map[string]bool{}
+	for cursor, pages := "", 0; pages == 0 || cursor != ""; pages++ {
+		records, nextCursor, err := s.List(ctx, cursor, 3)
+		assert.NoError(t, err)
+		assert.LessOrEqual(t, len(records), 3)
+
+		for _, record := range records {
+			assert.False(t, listed[record.Key])
+			listed[record.Key] = true
+		}
+		cursor = nextCursor
+	}
+	assert.Equal(t, expected, listed)
+}
+
+func TestEncryptedKeyFile(t *testing.T) {
+	path := t.TempDir() + "/key"
+	err := os.WriteFile(path, []byte(base64.StdEncoding.EncodeToString(make([]byte, 32))+"\n"), 0600)
+	assert.NoError(t, err)
+
+	setenv(t, "STORAGE_ENCRYPTION_KEY", "")
+	setenv(t, "STORAGE_ENCRYPTION_KEY_FILE", path)
+	_, err = storage.NewEncryptedStorage(storage.NewMemoryStorage())
+	assert.NoError(t, err)
+
+	setenv(t, "STORAGE_ENCRYPTION_KEY", base64.StdEncoding.EncodeToString(make([]byte, 16)))
+	_, err = storage.NewEncryptedStorage(storage.NewMemoryStorage())
+	assert.Error(t, err)
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"strings"
 	"time"
 
 	"cloud.google.com/go/firestore"
@@ -14,15 +15,75 @@ import (
 
 type (
 	FirestoreStorage struct {
+		client     *firestore.Client
 		collection *firestore.CollectionRef
+		// documents keyed by address, which guarantee uniqueness of address
+		addresses *firestore.CollectionRef
 	}
 
 	firestoreDocument struct {
-		Address string    `firestore:"address"`
-		Expires time.Time `firestore:"expires"`
+		Address       string    `firestore:"address"`
+		Expires       time.Time `firestore:"expires"`
+		Deliveries    int       `firestore:"deliveries"`
+		MaxDeliveries int       `firestore:"max_deliveries"`
+		Label         string    `firestore:"label"`
+		Note          string    `firestore:"note"`
+		Tags          []string  `firestore:"tags"`
+		CreatedAt     time.Time `firestore:"created_at"`
+		UpdatedAt     time.Time `firestore:"updated_at"`
 	}
+	firestoreAddressDocument struct {
+		Key string `firestore:"key"`
+	}
+)
+
+// document IDs cannot contain "/", which appears in keys derived from URL path
+var (
+	firestoreIDEscaper   = strings.NewReplacer("%", "%25", "/", "%2F")
+	firestoreIDUnescaper = strings.NewReplacer("%25", "%", "%2F", "/")
 )
 
+func firestoreID(key string) string {
+	return firestoreIDEscaper.Replace(key)
+}
+func firestoreKey(id string) string {
+	return firestoreIDUnescaper.Replace(id)
+}
+
+func newFirestoreDocument(record *Record) *firestoreDocument {
+	return &firestoreDocument{
+		Address:       record.Value,
+		Expires:       record.Expires,
+		Deliveries:    record.Deliveries,
+		MaxDeliveries: record.MaxDeliveries,
+		Label:         record.Label,
+		Note:          record.Note,
+		Tags:          record.Tags,
+		CreatedAt:     record.CreatedAt,
+		UpdatedAt:     record.UpdatedAt,
+	}
+}
+func readFirestoreDocument(snapshot *firestore.DocumentSnapshot) (*Record, error) {
+	data := &firestoreDocument{}
+	if err := snapshot.DataTo(&data); err != nil {
+		return nil, fmt.Errorf("failed to read document: %w", err)
+	}
+	return &Record{
+		Key:           firestoreKey(snapshot.Ref.ID),
+		Value:         data.Address,
+		Expires:       data.Expires,
+		Deliveries:    data.Deliveries,
+		MaxDeliveries: data.MaxDeliveries,
+		Metadata: Metadata{
+			Label: data.Label,
+			Note:  data.Note,
+			Tags:  data.Tags,
+		},
+		CreatedAt: data.CreatedAt,
+		UpdatedAt: data.UpdatedAt,
+	}, nil
+}
+
 func NewFirestoreStorage(ctx context.Context) (Storage, error) {
 	project := os.Getenv("GCP_PROJECT")
 	if project == "" {
@@ -40,12 +101,14 @@ func NewFirestoreStorage(ctx context.Context) (Storage, error) {
 	}
 
 	return &FirestoreStorage{
+		client:     client,
 		collection: client.Collection(collection),
+		addresses:  client.Collection(collection + "-addresses"),
 	}, nil
 }
 
-func (s *FirestoreStorage) findByKey(ctx context.Context, key string) (*firestore.DocumentSnapshot, error) {
-	snapshot, err := s.collection.Doc(key).Get(ctx)
+func (s *FirestoreStorage) findByKey(tx *firestore.Transaction, key string) (*firestore.DocumentSnapshot, error) {
+	snapshot, err := tx.Get(s.collection.Doc(firestoreID(key)))
 	if err != nil {
 		if status.Code(err) == codes.NotFound {
 			return nil, fmt.Errorf("%w: key=%v", ErrorUndefinedKey, key)
@@ -54,8 +117,25 @@ func (s *FirestoreStorage) findByKey(ctx context.Context, key string) (*firestor
 	}
 	return snapshot, nil
 }
-func (s *FirestoreStorage) findByValue(ctx context.Context, value string) (*firestore.DocumentSnapshot, error) {
-	snapshots, err := s.collection.Where("address", "==", value).Documents(ctx).GetAll()
+func (s *FirestoreStorage) findByValue(tx *firestore.Transaction, value string) (*firestore.DocumentSnapshot, error) {
+	index, err := tx.Get(s.addresses.Doc(firestoreID(value)))
+	if err == nil {
+		data := &firestoreAddressDocument{}
+		if err := index.DataTo(&data); err != nil {
+			return nil, fmt.Errorf("failed to read document: %w", err)
+		}
+
+		snapshot, err := s.findByKey(tx, data.Key)
+		if err != nil {
+			return nil, fmt.Errorf("address index is broken: %w", err)
+		}
+		return snapshot, nil
+	} else if status.Code(err) != codes.NotFound {
+		return nil, fmt.Errorf("failed to get document: %w", err)
+	}
+
+	// documents written before address index was introduced
+	snapshots, err := tx.Documents(s.collection.Where("address", "==", value).Limit(1)).GetAll()
 	if err != nil {
 		return nil, fmt.Errorf("failed to get documents: %w", err)
 	}
@@ -65,80 +145,218 @@ func (s *FirestoreStorage) findByValue(ctx context.Context, value string) (*fire
 	return snapshots[0], nil
 }
 
-func (s *FirestoreStorage) Get(ctx context.Context, key string) (string, error) {
-	snapshot, err := s.findByKey(ctx, key)
+func (s *FirestoreStorage) Get(ctx context.Context, key string) (*Record, error) {
+	snapshot, err := s.collection.Doc(firestoreID(key)).Get(ctx)
 	if err != nil {
-		return "", fmt.Errorf("failed to find document: %w", err)
+		if status.Code(err) == codes.NotFound {
+			return nil, fmt.Errorf("%w: key=%v", ErrorUndefinedKey, key)
+		}
+		return nil, fmt.Errorf("failed to get document: %w", err)
 	}
+	return readFirestoreDocument(snapshot)
+}
 
-	data := &firestoreDocument{}
-	if err := snapshot.DataTo(&data); err != nil {
-		return "", fmt.Errorf("failed to read document: %w", err)
+func (s *FirestoreStorage) GetByValue(ctx context.Context, value string) (*Record, error) {
+	var record *Record
+	err := s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snapshot, err := s.findByValue(tx, value)
+		if err != nil {
+			return fmt.Errorf("failed to find document: %w", err)
+		}
+		record, err = readFirestoreDocument(snapshot)
+		return err
+	}, firestore.ReadOnly)
+	if err != nil {
+		return nil, err
 	}
-	return data.Address, nil
+	return record, nil
 }
 
-func (s *FirestoreStorage) Set(ctx context.Context, key, value string, expires time.Time) error {
-	if _, err := s.findByKey(ctx, key); err == nil {
-		return fmt.Errorf("%w: key=%v", ErrorDuplicatedKey, key)
-	} else if !errors.Is(err, ErrorUndefinedKey) {
-		return fmt.Errorf("error occurred while querying by key: %v", err)
-	}
+func (s *FirestoreStorage) Set(ctx context.Context, record *Record) error {
+	return s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		if _, err := s.findByKey(tx, record.Key); err == nil {
+			return fmt.Errorf("%w: key=%v", ErrorDuplicatedKey, record.Key)
+		} else if !errors.Is(err, ErrorUndefinedKey) {
+			return fmt.Errorf("error occurred while querying by key: %w", err)
+		}
 
-	if _, err := s.findByValue(ctx, value); err == nil {
-		return fmt.Errorf("%w: value=%v", ErrorDuplicatedValue, value)
-	} else if !errors.Is(err, ErrorUndefinedValue) {
-		return fmt.Errorf("error occurred while querying by value: %v", err)
-	}
+		if _, err := s.findByValue(tx, record.Value); err == nil {
+			return fmt.Errorf("%w: value=%v", ErrorDuplicatedValue, record.Value)
+		} else if !errors.Is(err, ErrorUndefinedValue) {
+			return fmt.Errorf("error occurred while querying by value: %w", err)
+		}
 
-	if _, err := s.collection.Doc(key).Create(ctx, &firestoreDocument{value, expires}); err != nil {
-		return fmt.Errorf("failed to write document: %w", err)
-	}
-	return nil
+		if err := tx.Create(s.collection.Doc(firestoreID(record.Key)), newFirestoreDocument(record)); err != nil {
+			return fmt.Errorf("failed to write document: %w", err)
+		}
+		if err := tx.Create(s.addresses.Doc(firestoreID(record.Value)), &firestoreAddressDocument{record.Key}); err != nil {
+			return fmt.Errorf("failed to write address index: %w", err)
+		}
+		return nil
+	})
 }
 
-func (s *FirestoreStorage) unsetSnapshot(ctx context.Context, snapshot *firestore.DocumentSnapshot) (string, error) {
-	data := &firestoreDocument{}
-	if err := snapshot.DataTo(&data); err != nil {
-		return "", fmt.Errorf("failed to read document: %w", err)
+func (s *FirestoreStorage) unsetSnapshot(tx *firestore.Transaction, snapshot *firestore.DocumentSnapshot) (*Record, error) {
+	record, err := readFirestoreDocument(snapshot)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Delete(snapshot.Ref); err != nil {
+		return nil, fmt.Errorf("failed to delete document: %w", err)
 	}
+	if err := tx.Delete(s.addresses.Doc(firestoreID(record.Value))); err != nil {
+		return nil, fmt.Errorf("failed to delete address index: %w", err)
+	}
+	return record, nil
+}
 
-	if _, err := snapshot.Ref.Delete(ctx); err != nil {
-		return "", fmt.Errorf("failed to delete document: %w", err)
+func (s *FirestoreStorage) UnsetByKey(ctx context.Context, key string) (*Record, error) {
+	var record *Record
+	err := s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snapshot, err := s.findByKey(tx, key)
+		if err != nil {
+			return fmt.Errorf("failed to find document: %w", err)
+		}
+		record, err = s.unsetSnapshot(tx, snapshot)
+		return err
+	})
+	if err != nil {
+		return nil, err
 	}
-	return data.Address, nil
+	return record, nil
 }
 
-func (s *FirestoreStorage) UnsetByKey(ctx context.Context, key string) (string, error) {
-	snapshot, err := s.findByKey(ctx, key)
+func (s *FirestoreStorage) UnsetByValue(ctx context.Context, value string) (*Record, error) {
+	var record *Record
+	err := s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snapshot, err := s.findByValue(tx, value)
+		if err != nil {
+			return fmt.Errorf("failed to find document: %w", err)
+		}
+		record, err = s.unsetSnapshot(tx, snapshot)
+		return err
+	})
 	if err != nil {
-		return "", fmt.Errorf("failed to find document: %w", err)
+		return nil, err
 	}
-	return s.unsetSnapshot(ctx, snapshot)
+	return record, nil
 }
 
-func (s *FirestoreStorage) UnsetByValue(ctx context.Context, value string) (string, error) {
-	snapshot, err := s.findByValue(ctx, value)
+// update applies updates to the document of value, and fn to the record read from it.
+func (s *FirestoreStorage) update(ctx context.Context, value string, updates []firestore.Update, fn func(record *Record)) (*Record, error) {
+	var record *Record
+	err := s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snapshot, err := s.findByValue(tx, value)
+		if err != nil {
+			return fmt.Errorf("failed to find document: %w", err)
+		}
+		record, err = readFirestoreDocument(snapshot)
+		if err != nil {
+			return err
+		}
+
+		if err := tx.Update(snapshot.Ref, updates); err != nil {
+			return fmt.Errorf("failed to update document: %w", err)
+		}
+		fn(record)
+		return nil
+	})
 	if err != nil {
-		return "", fmt.Errorf("failed to find document: %w", err)
+		return nil, err
 	}
-	return s.unsetSnapshot(ctx, snapshot)
+	return record, nil
+}
+
+func (s *FirestoreStorage) CountDelivery(ctx context.Context, value string) (*Record, error) {
+	now := time.Now()
+	updates := []firestore.Update{{Path: "deliveries", Value: firestore.Increment(1)}, {Path: "updated_at", Value: now}}
+	return s.update(ctx, value, updates, func(record *Record) {
+		record.Deliveries++
+		record.UpdatedAt = now
+	})
+}
+
+func (s *FirestoreStorage) UpdateExpires(ctx context.Context, value string, expires time.Time) (*Record, error) {
+	now := time.Now()
+	updates := []firestore.Update{{Path: "expires", Value: expires}, {Path: "updated_at", Value: now}}
+	return s.update(ctx, value, updates, func(record *Record) {
+		record.Expires = expires
+		record.UpdatedAt = now
+	})
 }
 
 func (s *FirestoreStorage) UnsetExpired(ctx context.Context, until time.Time) ([]string, error) {
-	snapshots, err := s.collection.Where("expires", "<", until).Documents(ctx).GetAll()
+	refs, err := s.collection.Where("expires", "<", until).Select().Documents(ctx).GetAll()
 	if err != nil {
 		return nil, fmt.Errorf("failed to query: %w", err)
 	}
 
 	valuesExpired := []string{}
-	for _, snapshot := range snapshots {
-		addr, err := s.unsetSnapshot(ctx, snapshot)
-		if err != nil {
+	for _, ref := range refs {
+		var record *Record
+		err := s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+			snapshot, err := s.findByKey(tx, firestoreKey(ref.Ref.ID))
+			if err != nil {
+				return err
+			}
+
+			// the document may be replaced after query
+			data := &firestoreDocument{}
+			if err := snapshot.DataTo(&data); err != nil {
+				return fmt.Errorf("failed to read document: %w", err)
+			}
+			if !data.Expires.Before(until) {
+				record = nil
+				return nil
+			}
+
+			record, err = s.unsetSnapshot(tx, snapshot)
+			return err
+		})
+		if errors.Is(err, ErrorUndefinedKey) {
+			continue
+		} else if err != nil {
 			return nil, fmt.Errorf("error occured while deleting document: %w", err)
 		}
-		valuesExpired = append(valuesExpired, addr)
+		if record != nil {
+			valuesExpired = append(valuesExpired, record.Value)
+		}
 	}
 
 	return valuesExpired, nil
 }
+
+// records are listed in order of document ID, where "/" of keys sorts as escaped "%2F".
+// cursor is still the plain key of the last record, so that pagination works as usual.
+func (s *FirestoreStorage) List(ctx context.Context, cursor string, limit int) ([]*Record, string, error) {
+	if err := validateLimit(limit); err != nil {
+		return nil, "", err
+	}
+
+	query := s.collection.OrderBy(firestore.DocumentID, firestore.Asc).Limit(limit + 1)
+	if cursor != "" {
+		query = query.StartAfter(firestoreID(cursor))
+	}
+
+	snapshots, err := query.Documents(ctx).GetAll()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to query: %w", err)
+	}
+
+	nextCursor := ""
+	if len(snapshots) > limit {
+		snapshots = snapshots[:limit]
+		nextCursor = firestoreKey(snapshots[limit-1].Ref.ID)
+	}
+
+	records := make([]*Record, 0, len(snapshots))
+	for _, snapshot := range snapshots {
+		record, err := readFirestoreDocument(snapshot)
+		if err != nil {
+			return nil, "", err
+		}
+		records = append(records, record)
+	}
+	return records, nextCursor, nil
+}
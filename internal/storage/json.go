@@ -0,0 +1,61 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+type (
+	// representation of Record for key-value stores
+	jsonRecord struct {
+		Value   string    `json:"value"`
+		Expires time.Time `json:"expires"`
+		// omitted unless the record is a burner, to keep records compact
+		Deliveries    int       `json:"deliveries,omitempty"`
+		MaxDeliveries int       `json:"max_deliveries,omitempty"`
+		Label         string    `json:"label,omitempty"`
+		Note          string    `json:"note,omitempty"`
+		Tags          []string  `json:"tags,omitempty"`
+		CreatedAt     time.Time `json:"created_at"`
+		UpdatedAt     time.Time `json:"updated_at"`
+	}
+)
+
+func encodeJSONRecord(record *Record) ([]byte, error) {
+	encoded, err := json.Marshal(&jsonRecord{
+		Value:         record.Value,
+		Expires:       record.Expires,
+		Deliveries:    record.Deliveries,
+		MaxDeliveries: record.MaxDeliveries,
+		Label:         record.Label,
+		Note:          record.Note,
+		Tags:          record.Tags,
+		CreatedAt:     record.CreatedAt,
+		UpdatedAt:     record.UpdatedAt,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode record: %w", err)
+	}
+	return encoded, nil
+}
+func decodeJSONRecord(key string, encoded []byte) (*Record, error) {
+	data := &jsonRecord{}
+	if err := json.Unmarshal(encoded, data); err != nil {
+		return nil, fmt.Errorf("failed to decode record: %w", err)
+	}
+	return &Record{
+		Key:           key,
+		Value:         data.Value,
+		Expires:       data.Expires,
+		Deliveries:    data.Deliveries,
+		MaxDeliveries: data.MaxDeliveries,
+		Metadata: Metadata{
+			Label: data.Label,
+			Note:  data.Note,
+			Tags:  data.Tags,
+		},
+		CreatedAt: data.CreatedAt,
+		UpdatedAt: data.UpdatedAt,
+	}, nil
+}
@@ -1,89 +1,183 @@
 package storage
 
 import (
+	"container/heap"
 	"context"
 	"fmt"
+	"sort"
 	"sync"
 	"time"
 )
 
 type (
 	MemoryStorage struct {
-		data map[string]memoryStorageEntry
-		mu   sync.RWMutex
+		data map[string]*memoryEntry
+		// value -> key
+		values map[string]string
+		// every key in order, for listing pages without sorting
+		keys []string
+		// entries ordered by expires, excluding those which never expire
+		expiries memoryExpiryHeap
+		mu       sync.RWMutex
 	}
-	memoryStorageEntry struct {
-		value   string
-		expires time.Time
+
+	memoryEntry struct {
+		record *Record
+		// position in expiries, or -1 if not indexed
+		index int
 	}
+	memoryExpiryHeap []*memoryEntry
 )
 
+func (h memoryExpiryHeap) Len() int {
+	return len(h)
+}
+func (h memoryExpiryHeap) Less(i, j int) bool {
+	return h[i].record.Expires.Before(h[j].record.Expires)
+}
+func (h memoryExpiryHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+func (h *memoryExpiryHeap) Push(x interface{}) {
+	entry := x.(*memoryEntry)
+	entry.index = len(*h)
+	*h = append(*h, entry)
+}
+func (h *memoryExpiryHeap) Pop() interface{} {
+	old := *h
+	entry := old[len(old)-1]
+	old[len(old)-1] = nil
+	entry.index = -1
+	*h = old[:len(old)-1]
+	return entry
+}
+
 func NewMemoryStorage() Storage {
 	return &MemoryStorage{
-		data: map[string]memoryStorageEntry{},
-		mu:   sync.RWMutex{},
+		data:     map[string]*memoryEntry{},
+		values:   map[string]string{},
+		keys:     []string{},
+		expiries: memoryExpiryHeap{},
+		mu:       sync.RWMutex{},
 	}
 }
 
-func (s *MemoryStorage) find(needle string) (string, bool) {
-	for key, entry := range s.data {
-		if entry.value == needle {
-			return key, true
-		}
+func (s *MemoryStorage) unset(key string) *Record {
+	entry := s.data[key]
+	if entry.index >= 0 {
+		heap.Remove(&s.expiries, entry.index)
 	}
-	return "", false
+	delete(s.values, entry.record.Value)
+	delete(s.data, key)
+
+	i := sort.SearchStrings(s.keys, key)
+	s.keys = append(s.keys[:i], s.keys[i+1:]...)
+	return entry.record
 }
 
-func (s *MemoryStorage) Get(ctx context.Context, key string) (string, error) {
+func (s *MemoryStorage) Get(ctx context.Context, key string) (*Record, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
 	entry, ok := s.data[key]
 	if !ok {
-		return "", fmt.Errorf("%w: key=%v", ErrorUndefinedKey, key)
+		return nil, fmt.Errorf("%w: key=%v", ErrorUndefinedKey, key)
+	}
+	return entry.record.clone(), nil
+}
+
+func (s *MemoryStorage) GetByValue(ctx context.Context, value string) (*Record, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	key, ok := s.values[value]
+	if !ok {
+		return nil, fmt.Errorf("%w: value=%v", ErrorUndefinedValue, value)
 	}
-	return entry.value, nil
+	return s.data[key].record.clone(), nil
 }
 
-func (s *MemoryStorage) Set(ctx context.Context, key, value string, expires time.Time) error {
+func (s *MemoryStorage) Set(ctx context.Context, record *Record) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	if _, ok := s.data[key]; ok {
-		return fmt.Errorf("%w: key=%v", ErrorDuplicatedKey, key)
+	if _, ok := s.data[record.Key]; ok {
+		return fmt.Errorf("%w: key=%v", ErrorDuplicatedKey, record.Key)
+	}
+	if _, ok := s.values[record.Value]; ok {
+		return fmt.Errorf("%w: value=%v", ErrorDuplicatedValue, record.Value)
 	}
-	if _, ok := s.find(value); ok {
-		return fmt.Errorf("%w: value=%v", ErrorDuplicatedValue, value)
+
+	entry := &memoryEntry{record: record.clone(), index: -1}
+	if !record.Expires.Equal(NeverExpire) {
+		heap.Push(&s.expiries, entry)
 	}
+	s.data[record.Key] = entry
+	s.values[record.Value] = record.Key
 
-	s.data[key] = memoryStorageEntry{value, expires}
+	i := sort.SearchStrings(s.keys, record.Key)
+	s.keys = append(s.keys, "")
+	copy(s.keys[i+1:], s.keys[i:])
+	s.keys[i] = record.Key
 	return nil
 }
 
-func (s *MemoryStorage) UnsetByKey(ctx context.Context, key string) (string, error) {
+func (s *MemoryStorage) UnsetByKey(ctx context.Context, key string) (*Record, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	entry, ok := s.data[key]
+	if _, ok := s.data[key]; !ok {
+		return nil, fmt.Errorf("%w: key=%v", ErrorUndefinedKey, key)
+	}
+	return s.unset(key), nil
+}
+
+func (s *MemoryStorage) UnsetByValue(ctx context.Context, value string) (*Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, ok := s.values[value]
 	if !ok {
-		return "", fmt.Errorf("%w: key=%v", ErrorUndefinedKey, key)
+		return nil, fmt.Errorf("%w: value=%v", ErrorUndefinedValue, value)
 	}
+	return s.unset(key), nil
+}
 
-	delete(s.data, key)
-	return entry.value, nil
+func (s *MemoryStorage) CountDelivery(ctx context.Context, value string) (*Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, ok := s.values[value]
+	if !ok {
+		return nil, fmt.Errorf("%w: value=%v", ErrorUndefinedValue, value)
+	}
+	record := s.data[key].record
+	record.Deliveries++
+	record.UpdatedAt = time.Now()
+	return record.clone(), nil
 }
 
-func (s *MemoryStorage) UnsetByValue(ctx context.Context, value string) (string, error) {
+func (s *MemoryStorage) UpdateExpires(ctx context.Context, value string, expires time.Time) (*Record, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	key, ok := s.find(value)
+	key, ok := s.values[value]
 	if !ok {
-		return "", fmt.Errorf("%w: value=%v", ErrorUndefinedValue, value)
+		return nil, fmt.Errorf("%w: value=%v", ErrorUndefinedValue, value)
 	}
 
-	delete(s.data, key)
-	return value, nil
+	entry := s.data[key]
+	if entry.index >= 0 {
+		heap.Remove(&s.expiries, entry.index)
+	}
+	entry.record.Expires = expires
+	entry.record.UpdatedAt = time.Now()
+	if !expires.Equal(NeverExpire) {
+		heap.Push(&s.expiries, entry)
+	}
+	return entry.record.clone(), nil
 }
 
 func (s *MemoryStorage) UnsetExpired(ctx context.Context, until time.Time) ([]string, error) {
@@ -91,12 +185,36 @@ func (s *MemoryStorage) UnsetExpired(ctx context.Context, until time.Time) ([]st
 	defer s.mu.Unlock()
 
 	valuesExpired := []string{}
-
-	for key, entry := range s.data {
-		if until.After(entry.expires) {
-			valuesExpired = append(valuesExpired, entry.value)
-			delete(s.data, key)
-		}
+	for len(s.expiries) > 0 && until.After(s.expiries[0].record.Expires) {
+		record := s.unset(s.expiries[0].record.Key)
+		valuesExpired = append(valuesExpired, record.Value)
 	}
 	return valuesExpired, nil
 }
+
+func (s *MemoryStorage) List(ctx context.Context, cursor string, limit int) ([]*Record, string, error) {
+	if err := validateLimit(limit); err != nil {
+		return nil, "", err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	start := sort.SearchStrings(s.keys, cursor)
+	if start < len(s.keys) && s.keys[start] == cursor {
+		start++
+	}
+	keys := s.keys[start:]
+
+	nextCursor := ""
+	if len(keys) > limit {
+		keys = keys[:limit]
+		nextCursor = keys[limit-1]
+	}
+
+	records := make([]*Record, 0, len(keys))
+	for _, key := range keys {
+		records = append(records, s.data[key].record.clone())
+	}
+	return records, nextCursor, nil
+}
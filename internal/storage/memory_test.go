@@ -0,0 +1,104 @@
+package storage_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/kaz/private-email-relay/internal/storage"
+)
+
+var benchmarkSizes = []int{1000, 10000, 100000}
+
+func newPopulatedMemoryStorage(b *testing.B, size int) storage.Storage {
+	b.Helper()
+
+	s := storage.NewMemoryStorage()
+	expires := time.Now().Add(time.Hour)
+	for i := 0; i < size; i++ {
+		record := &storage.Record{Key: fmt.Sprintf("key%d", i), Value: fmt.Sprintf("value%d", i), Expires: expires}
+		if err := s.Set(ctx, record); err != nil {
+			b.Fatal(err)
+		}
+	}
+	return s
+}
+
+func BenchmarkMemorySet(b *testing.B) {
+	for _, size := range benchmarkSizes {
+		b.Run(fmt.Sprintf("size=%d", size), func(b *testing.B) {
+			s := newPopulatedMemoryStorage(b, size)
+			expires := time.Now().Add(time.Hour)
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				record := &storage.Record{Key: fmt.Sprintf("new%d", i), Value: fmt.Sprintf("new%d", i), Expires: expires}
+				if err := s.Set(ctx, record); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// each iteration puts the record back, so that the size stays the same
+func BenchmarkMemoryUnsetByValue(b *testing.B) {
+	for _, size := range benchmarkSizes {
+		b.Run(fmt.Sprintf("size=%d", size), func(b *testing.B) {
+			s := newPopulatedMemoryStorage(b, size)
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				record, err := s.UnsetByValue(ctx, fmt.Sprintf("value%d", i%size))
+				if err != nil {
+					b.Fatal(err)
+				}
+				if err := s.Set(ctx, record); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// each iteration expires only one record among the others which are still alive
+func BenchmarkMemoryUnsetExpired(b *testing.B) {
+	for _, size := range benchmarkSizes {
+		b.Run(fmt.Sprintf("size=%d", size), func(b *testing.B) {
+			s := newPopulatedMemoryStorage(b, size)
+			now := time.Now()
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				record := &storage.Record{Key: "expired", Value: "expired", Expires: now.Add(-time.Second)}
+				if err := s.Set(ctx, record); err != nil {
+					b.Fatal(err)
+				}
+
+				values, err := s.UnsetExpired(ctx, now)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if len(values) != 1 {
+					b.Fatalf("expected 1 expired record, got %d", len(values))
+				}
+			}
+		})
+	}
+}
+
+// each iteration lists a page in the middle of the records
+func BenchmarkMemoryList(b *testing.B) {
+	for _, size := range benchmarkSizes {
+		b.Run(fmt.Sprintf("size=%d", size), func(b *testing.B) {
+			s := newPopulatedMemoryStorage(b, size)
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				if _, _, err := s.List(ctx, fmt.Sprintf("key%d", i%size), 100); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+type (
+	MigrateOptions struct {
+		// records are copied starting after this key, to resume an interrupted migration
+		Cursor string
+		// number of records read from the source at once
+		PageSize int
+		// called after each page with the cursor to resume from
+		Checkpoint func(cursor string) error
+	}
+
+	MigrateReport struct {
+		// copied by this run
+		Migrated []string
+		// already copied with the same address, by an earlier run
+		Skipped []string
+		// not copied, since the key or the address is taken by another record
+		Conflicts []string
+		// last key processed, to resume from
+		Cursor string
+	}
+)
+
+var (
+	errorAlreadyMigrated = fmt.Errorf("already migrated")
+)
+
+const (
+	defaultMigratePageSize = 100
+)
+
+// Migrate copies every record from one storage to another, keeping expiry and metadata.
+// Records conflicting with the destination are reported instead of overwritten.
+func Migrate(ctx context.Context, from, to Storage, opts MigrateOptions) (*MigrateReport, error) {
+	pageSize := opts.PageSize
+	if pageSize <= 0 {
+		pageSize = defaultMigratePageSize
+	}
+
+	report := &MigrateReport{
+		Migrated:  []string{},
+		Skipped:   []string{},
+		Conflicts: []string{},
+		Cursor:    opts.Cursor,
+	}
+	for cursor, first := opts.Cursor, true; first || cursor != ""; first = false {
+		records, nextCursor, err := from.List(ctx, cursor, pageSize)
+		if err != nil {
+			return report, fmt.Errorf("failed to list source: %w", err)
+		}
+
+		for _, record := range records {
+			if err := migrateRecord(ctx, to, record); errors.Is(err, errorAlreadyMigrated) {
+				report.Skipped = append(report.Skipped, record.Key)
+			} else if errors.Is(err, ErrorDuplicatedKey) || errors.Is(err, ErrorDuplicatedValue) {
+				report.Conflicts = append(report.Conflicts, fmt.Sprintf("%v: %v", record.Key, err))
+			} else if err != nil {
+				return report, fmt.Errorf("failed to migrate record: key=%v: %w", record.Key, err)
+			} else {
+				report.Migrated = append(report.Migrated, record.Key)
+			}
+			report.Cursor = record.Key
+		}
+
+		if opts.Checkpoint != nil && len(records) > 0 {
+			if err := opts.Checkpoint(report.Cursor); err != nil {
+				return report, fmt.Errorf("failed to save checkpoint: %w", err)
+			}
+		}
+		cursor = nextCursor
+	}
+	return report, nil
+}
+
+func migrateRecord(ctx context.Context, to Storage, record *Record) error {
+	err := to.Set(ctx, record)
+	if !errors.Is(err, ErrorDuplicatedKey) {
+		return err
+	}
+
+	// the same record may be copied by an interrupted run
+	existing, getErr := to.Get(ctx, record.Key)
+	if getErr != nil {
+		return fmt.Errorf("failed to get existing record: %w", getErr)
+	}
+	if existing.Value == record.Value {
+		return errorAlreadyMigrated
+	}
+	return fmt.Errorf("%w: key=%v is bound to %v", ErrorDuplicatedKey, record.Key, existing.Value)
+}
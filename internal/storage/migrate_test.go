@@ -0,0 +1,100 @@
+package storage_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/kaz/private-email-relay/internal/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+func newMigrateSource(t *testing.T, size int) storage.Storage {
+	t.Helper()
+
+	s := storage.NewMemoryStorage()
+	for i := 0; i < size; i++ {
+		record := &storage.Record{
+			Key:     fmt.Sprintf("testMigrate-%02d.test", i),
+			Value:   fmt.Sprintf("testMigrate-%02d@test.test", i),
+			Expires: time.Now().Add(time.Duration(i) * time.Hour),
+			Metadata: storage.Metadata{
+				Label: fmt.Sprintf("label%d", i),
+				Tags:  []string{"tag"},
+			},
+		}
+		assert.NoError(t, s.Set(ctx, record))
+	}
+	return s
+}
+
+func TestMigrate(t *testing.T) {
+	from := newMigrateSource(t, 25)
+	to := storage.NewMemoryStorage()
+
+	checkpoints := []string{}
+	report, err := storage.Migrate(ctx, from, to, storage.MigrateOptions{
+		PageSize: 10,
+		Checkpoint: func(cursor string) error {
+			checkpoints = append(checkpoints, cursor)
+			return nil
+		},
+	})
+	assert.NoError(t, err)
+	assert.Len(t, report.Migrated, 25)
+	assert.Empty(t, report.Skipped)
+	assert.Empty(t, report.Conflicts)
+	assert.Equal(t, "testMigrate-24.test", report.Cursor)
+	assert.Equal(t, []string{"testMigrate-09.test", "testMigrate-19.test", "testMigrate-24.test"}, checkpoints)
+
+	records, _, err := from.List(ctx, "", 100)
+	assert.NoError(t, err)
+	for _, record := range records {
+		got, err := to.Get(ctx, record.Key)
+		assert.NoError(t, err)
+		assert.Equal(t, record.Value, got.Value)
+		assert.Equal(t, record.Metadata, got.Metadata)
+		assert.True(t, record.Expires.Equal(got.Expires))
+	}
+}
+
+func TestMigrateResume(t *testing.T) {
+	from := newMigrateSource(t, 25)
+	to := storage.NewMemoryStorage()
+
+	// interrupted in the middle of a page
+	for _, key := range []string{"testMigrate-00.test", "testMigrate-01.test", "testMigrate-02.test"} {
+		record, err := from.Get(ctx, key)
+		assert.NoError(t, err)
+		assert.NoError(t, to.Set(ctx, record))
+	}
+
+	report, err := storage.Migrate(ctx, from, to, storage.MigrateOptions{PageSize: 10})
+	assert.NoError(t, err)
+	assert.Len(t, report.Migrated, 22)
+	assert.Equal(t, []string{"testMigrate-00.test", "testMigrate-01.test", "testMigrate-02.test"}, report.Skipped)
+	assert.Empty(t, report.Conflicts)
+
+	// resumed from the cursor
+	report, err = storage.Migrate(ctx, from, to, storage.MigrateOptions{Cursor: "testMigrate-19.test"})
+	assert.NoError(t, err)
+	assert.Empty(t, report.Migrated)
+	assert.Len(t, report.Skipped, 5)
+}
+
+func TestMigrateConflict(t *testing.T) {
+	from := newMigrateSource(t, 3)
+	to := storage.NewMemoryStorage()
+
+	assert.NoError(t, to.Set(ctx, &storage.Record{Key: "testMigrate-00.test", Value: "other@test.test", Expires: storage.NeverExpire}))
+	assert.NoError(t, to.Set(ctx, &storage.Record{Key: "other.test", Value: "testMigrate-01@test.test", Expires: storage.NeverExpire}))
+
+	report, err := storage.Migrate(ctx, from, to, storage.MigrateOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"testMigrate-02.test"}, report.Migrated)
+	assert.Len(t, report.Conflicts, 2)
+
+	got, err := to.Get(ctx, "testMigrate-00.test")
+	assert.NoError(t, err)
+	assert.Equal(t, "other@test.test", got.Value)
+}
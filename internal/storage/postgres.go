@@ -0,0 +1,252 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+type (
+	PostgresStorage struct {
+		db *sql.DB
+	}
+)
+
+var (
+	// applied in order, and never modified once released
+	postgresMigrations = []string{
+		`
+CREATE TABLE relays (
+	key        TEXT COLLATE "C" NOT NULL,
+	address    TEXT             NOT NULL,
+	expires    TIMESTAMPTZ      NOT NULL,
+	label      TEXT             NOT NULL DEFAULT '',
+	note       TEXT             NOT NULL DEFAULT '',
+	tags       TEXT[]           NOT NULL DEFAULT '{}',
+	created_at TIMESTAMPTZ      NOT NULL,
+	updated_at TIMESTAMPTZ      NOT NULL,
+	CONSTRAINT relays_key_unique PRIMARY KEY (key),
+	CONSTRAINT relays_address_unique UNIQUE (address)
+);
+CREATE INDEX relays_expires ON relays (expires);
+`,
+		`
+ALTER TABLE relays ADD COLUMN deliveries INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE relays ADD COLUMN max_deliveries INTEGER NOT NULL DEFAULT 0;
+`,
+	}
+)
+
+const (
+	postgresColumns = "key, address, expires, deliveries, max_deliveries, label, note, tags, created_at, updated_at"
+
+	// arbitrary number to serialize migrations among instances starting at once
+	postgresMigrationLockID = 0x72656c6179
+)
+
+func NewPostgresStorage(ctx context.Context) (Storage, error) {
+	dsn := os.Getenv("POSTGRES_DSN")
+	if dsn == "" {
+		return nil, fmt.Errorf("POSTGRES_DSN is missing")
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open PostgreSQL database: %w", err)
+	}
+
+	if err := migratePostgres(ctx, db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to migrate schema: %w", err)
+	}
+
+	return &PostgresStorage{db}, nil
+}
+
+func migratePostgres(ctx context.Context, db *sql.DB) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock($1)", postgresMigrationLockID); err != nil {
+		return fmt.Errorf("failed to acquire lock: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS relay_schema_migrations (version INTEGER NOT NULL PRIMARY KEY, applied_at TIMESTAMPTZ NOT NULL)"); err != nil {
+		return fmt.Errorf("failed to create migration table: %w", err)
+	}
+
+	var version int
+	if err := tx.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM relay_schema_migrations").Scan(&version); err != nil {
+		return fmt.Errorf("failed to determine schema version: %w", err)
+	}
+
+	for ; version < len(postgresMigrations); version++ {
+		if _, err := tx.ExecContext(ctx, postgresMigrations[version]); err != nil {
+			return fmt.Errorf("failed to apply migration #%d: %w", version+1, err)
+		}
+		if _, err := tx.ExecContext(ctx, "INSERT INTO relay_schema_migrations (version, applied_at) VALUES ($1, $2)", version+1, time.Now()); err != nil {
+			return fmt.Errorf("failed to record migration #%d: %w", version+1, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit: %w", err)
+	}
+	return nil
+}
+
+func scanPostgresRecord(row rowScanner) (*Record, error) {
+	record := &Record{}
+	if err := row.Scan(&record.Key, &record.Value, &record.Expires, &record.Deliveries, &record.MaxDeliveries, &record.Label, &record.Note, pq.Array(&record.Tags), &record.CreatedAt, &record.UpdatedAt); err != nil {
+		return nil, err
+	}
+	if len(record.Tags) == 0 {
+		record.Tags = nil
+	}
+	return record, nil
+}
+
+func (s *PostgresStorage) Get(ctx context.Context, key string) (*Record, error) {
+	record, err := scanPostgresRecord(s.db.QueryRowContext(ctx, "SELECT "+postgresColumns+" FROM relays WHERE key = $1", key))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%w: key=%v", ErrorUndefinedKey, key)
+		}
+		return nil, fmt.Errorf("failed to query: %w", err)
+	}
+	return record, nil
+}
+
+func (s *PostgresStorage) GetByValue(ctx context.Context, value string) (*Record, error) {
+	record, err := scanPostgresRecord(s.db.QueryRowContext(ctx, "SELECT "+postgresColumns+" FROM relays WHERE address = $1", value))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%w: value=%v", ErrorUndefinedValue, value)
+		}
+		return nil, fmt.Errorf("failed to query: %w", err)
+	}
+	return record, nil
+}
+
+func (s *PostgresStorage) Set(ctx context.Context, record *Record) error {
+	tags := record.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+
+	_, err := s.db.ExecContext(ctx,
+		"INSERT INTO relays ("+postgresColumns+") VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)",
+		record.Key, record.Value, record.Expires, record.Deliveries, record.MaxDeliveries, record.Label, record.Note, pq.Array(tags), record.CreatedAt, record.UpdatedAt,
+	)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code.Name() == "unique_violation" {
+			switch pqErr.Constraint {
+			case "relays_key_unique":
+				return fmt.Errorf("%w: key=%v", ErrorDuplicatedKey, record.Key)
+			case "relays_address_unique":
+				return fmt.Errorf("%w: value=%v", ErrorDuplicatedValue, record.Value)
+			}
+		}
+		return fmt.Errorf("failed to insert: %w", err)
+	}
+	return nil
+}
+
+func (s *PostgresStorage) unset(ctx context.Context, column, needle string, undefined error) (*Record, error) {
+	record, err := scanPostgresRecord(s.db.QueryRowContext(ctx, "DELETE FROM relays WHERE "+column+" = $1 RETURNING "+postgresColumns, needle))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%w: %v=%v", undefined, column, needle)
+		}
+		return nil, fmt.Errorf("failed to delete: %w", err)
+	}
+	return record, nil
+}
+
+func (s *PostgresStorage) UnsetByKey(ctx context.Context, key string) (*Record, error) {
+	return s.unset(ctx, "key", key, ErrorUndefinedKey)
+}
+
+func (s *PostgresStorage) UnsetByValue(ctx context.Context, value string) (*Record, error) {
+	return s.unset(ctx, "address", value, ErrorUndefinedValue)
+}
+
+// update applies assignments, such as "expires = $2", to the record of value, which is the first placeholder.
+func (s *PostgresStorage) update(ctx context.Context, value string, assignments string, args ...interface{}) (*Record, error) {
+	record, err := scanPostgresRecord(s.db.QueryRowContext(ctx, "UPDATE relays SET "+assignments+" WHERE address = $1 RETURNING "+postgresColumns, append([]interface{}{value}, args...)...))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%w: value=%v", ErrorUndefinedValue, value)
+		}
+		return nil, fmt.Errorf("failed to update: %w", err)
+	}
+	return record, nil
+}
+
+func (s *PostgresStorage) CountDelivery(ctx context.Context, value string) (*Record, error) {
+	return s.update(ctx, value, "deliveries = deliveries + 1, updated_at = $2", time.Now())
+}
+
+func (s *PostgresStorage) UpdateExpires(ctx context.Context, value string, expires time.Time) (*Record, error) {
+	return s.update(ctx, value, "expires = $2, updated_at = $3", expires, time.Now())
+}
+
+func (s *PostgresStorage) UnsetExpired(ctx context.Context, until time.Time) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, "DELETE FROM relays WHERE expires < $1 RETURNING address", until)
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete: %w", err)
+	}
+	defer rows.Close()
+
+	valuesExpired := []string{}
+	for rows.Next() {
+		var value string
+		if err := rows.Scan(&value); err != nil {
+			return nil, fmt.Errorf("failed to read row: %w", err)
+		}
+		valuesExpired = append(valuesExpired, value)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read rows: %w", err)
+	}
+	return valuesExpired, nil
+}
+
+func (s *PostgresStorage) List(ctx context.Context, cursor string, limit int) ([]*Record, string, error) {
+	if err := validateLimit(limit); err != nil {
+		return nil, "", err
+	}
+
+	rows, err := s.db.QueryContext(ctx, "SELECT "+postgresColumns+" FROM relays WHERE key > $1 ORDER BY key LIMIT $2", cursor, limit+1)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to query: %w", err)
+	}
+	defer rows.Close()
+
+	records := []*Record{}
+	for rows.Next() {
+		record, err := scanPostgresRecord(rows)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to read row: %w", err)
+		}
+		records = append(records, record)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", fmt.Errorf("failed to read rows: %w", err)
+	}
+
+	nextCursor := ""
+	if len(records) > limit {
+		records = records[:limit]
+		nextCursor = records[limit-1].Key
+	}
+	return records, nextCursor, nil
+}
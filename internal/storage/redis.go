@@ -0,0 +1,405 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+type (
+	RedisStorage struct {
+		client *redis.Client
+		prefix string
+	}
+)
+
+const (
+	redisTransactionAttempts = 16
+
+	// records outlive their expiry for a while, so that they are readable until UnsetExpired reaps them as in other backends.
+	// TTL is a backstop for when UnsetExpired is not called.
+	redisExpiryGrace = 24 * time.Hour
+)
+
+func NewRedisStorage(ctx context.Context) (Storage, error) {
+	url := os.Getenv("REDIS_URL")
+	if url == "" {
+		return nil, fmt.Errorf("REDIS_URL is missing")
+	}
+
+	prefix := os.Getenv("REDIS_PREFIX")
+	if prefix == "" {
+		prefix = "private-email-relay:"
+	}
+
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse REDIS_URL: %w", err)
+	}
+
+	client := redis.NewClient(opts)
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to connect to Redis: %w", err)
+	}
+
+	return &RedisStorage{client, prefix}, nil
+}
+
+// key -> record
+func (s *RedisStorage) recordKey(key string) string {
+	return s.prefix + "record:" + key
+}
+
+// address -> key
+func (s *RedisStorage) addressKey(value string) string {
+	return s.prefix + "address:" + value
+}
+
+// sorted set of every key, for listing in order
+func (s *RedisStorage) keysKey() string {
+	return s.prefix + "keys"
+}
+
+// sorted set of addresses scored by expires.
+// records vanish by TTL, but addresses are kept here until UnsetExpired reports them.
+func (s *RedisStorage) expiresKey() string {
+	return s.prefix + "expires"
+}
+
+func redisMillis(t time.Time) int64 {
+	return toMicros(t) / 1e3
+}
+
+func (s *RedisStorage) transact(ctx context.Context, fn func(tx *redis.Tx) error, keys ...string) error {
+	for i := 0; i < redisTransactionAttempts; i++ {
+		err := s.client.Watch(ctx, fn, keys...)
+		if !errors.Is(err, redis.TxFailedErr) {
+			return err
+		}
+	}
+	return fmt.Errorf("transaction conflicted %d times", redisTransactionAttempts)
+}
+
+func (s *RedisStorage) get(ctx context.Context, cmd redis.Cmdable, key string) (*Record, error) {
+	encoded, err := cmd.Get(ctx, s.recordKey(key)).Bytes()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, fmt.Errorf("%w: key=%v", ErrorUndefinedKey, key)
+		}
+		return nil, fmt.Errorf("failed to get record: %w", err)
+	}
+	return decodeJSONRecord(key, encoded)
+}
+
+func (s *RedisStorage) unset(ctx context.Context, tx *redis.Tx, key string) (*Record, error) {
+	record, err := s.get(ctx, tx, key)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Watch(ctx, s.addressKey(record.Value)).Err(); err != nil {
+		return nil, fmt.Errorf("failed to watch address index: %w", err)
+	}
+
+	_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, s.recordKey(key), s.addressKey(record.Value))
+		pipe.ZRem(ctx, s.keysKey(), key)
+		pipe.ZRem(ctx, s.expiresKey(), record.Value)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+func (s *RedisStorage) Get(ctx context.Context, key string) (*Record, error) {
+	return s.get(ctx, s.client, key)
+}
+
+func (s *RedisStorage) GetByValue(ctx context.Context, value string) (*Record, error) {
+	key, err := s.client.Get(ctx, s.addressKey(value)).Result()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, fmt.Errorf("%w: value=%v", ErrorUndefinedValue, value)
+		}
+		return nil, fmt.Errorf("failed to get address index: %w", err)
+	}
+
+	record, err := s.get(ctx, s.client, key)
+	if err != nil {
+		// unset since the address index is read
+		if errors.Is(err, ErrorUndefinedKey) {
+			return nil, fmt.Errorf("%w: value=%v", ErrorUndefinedValue, value)
+		}
+		return nil, err
+	}
+	return record, nil
+}
+
+func (s *RedisStorage) Set(ctx context.Context, record *Record) error {
+	encoded, err := encodeJSONRecord(record)
+	if err != nil {
+		return err
+	}
+
+	recordKey := s.recordKey(record.Key)
+	addressKey := s.addressKey(record.Value)
+
+	return s.transact(ctx, func(tx *redis.Tx) error {
+		if n, err := tx.Exists(ctx, recordKey).Result(); err != nil {
+			return fmt.Errorf("error occurred while querying by key: %w", err)
+		} else if n > 0 {
+			return fmt.Errorf("%w: key=%v", ErrorDuplicatedKey, record.Key)
+		}
+
+		if n, err := tx.Exists(ctx, addressKey).Result(); err != nil {
+			return fmt.Errorf("error occurred while querying by value: %w", err)
+		} else if n > 0 {
+			return fmt.Errorf("%w: value=%v", ErrorDuplicatedValue, record.Value)
+		}
+
+		// expired by TTL, but not reported by UnsetExpired yet
+		if err := tx.ZScore(ctx, s.expiresKey(), record.Value).Err(); err == nil {
+			return fmt.Errorf("%w: value=%v", ErrorDuplicatedValue, record.Value)
+		} else if !errors.Is(err, redis.Nil) {
+			return fmt.Errorf("error occurred while querying by value: %w", err)
+		}
+
+		_, err := tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, recordKey, encoded, 0)
+			pipe.Set(ctx, addressKey, record.Key, 0)
+			pipe.ZAdd(ctx, s.keysKey(), &redis.Z{Score: 0, Member: record.Key})
+
+			if !record.Expires.Equal(NeverExpire) {
+				pipe.PExpireAt(ctx, recordKey, record.Expires.Add(redisExpiryGrace))
+				pipe.PExpireAt(ctx, addressKey, record.Expires.Add(redisExpiryGrace))
+				pipe.ZAdd(ctx, s.expiresKey(), &redis.Z{Score: float64(redisMillis(record.Expires)), Member: record.Value})
+			}
+			return nil
+		})
+		return err
+	}, recordKey, addressKey, s.expiresKey())
+}
+
+func (s *RedisStorage) UnsetByKey(ctx context.Context, key string) (*Record, error) {
+	var record *Record
+	err := s.transact(ctx, func(tx *redis.Tx) error {
+		var err error
+		record, err = s.unset(ctx, tx, key)
+		return err
+	}, s.recordKey(key))
+	if err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+func (s *RedisStorage) UnsetByValue(ctx context.Context, value string) (*Record, error) {
+	var record *Record
+	err := s.transact(ctx, func(tx *redis.Tx) error {
+		key, err := tx.Get(ctx, s.addressKey(value)).Result()
+		if err != nil {
+			if errors.Is(err, redis.Nil) {
+				return fmt.Errorf("%w: value=%v", ErrorUndefinedValue, value)
+			}
+			return fmt.Errorf("failed to get address index: %w", err)
+		}
+		if err := tx.Watch(ctx, s.recordKey(key)).Err(); err != nil {
+			return fmt.Errorf("failed to watch record: %w", err)
+		}
+
+		record, err = s.unset(ctx, tx, key)
+		return err
+	}, s.addressKey(value))
+	if err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// update rewrites the record of value by fn, and its TTL and the expiry index as well.
+func (s *RedisStorage) update(ctx context.Context, value string, fn func(record *Record)) (*Record, error) {
+	var record *Record
+	err := s.transact(ctx, func(tx *redis.Tx) error {
+		key, err := tx.Get(ctx, s.addressKey(value)).Result()
+		if err != nil {
+			if errors.Is(err, redis.Nil) {
+				return fmt.Errorf("%w: value=%v", ErrorUndefinedValue, value)
+			}
+			return fmt.Errorf("failed to get address index: %w", err)
+		}
+		recordKey := s.recordKey(key)
+		if err := tx.Watch(ctx, recordKey).Err(); err != nil {
+			return fmt.Errorf("failed to watch record: %w", err)
+		}
+
+		record, err = s.get(ctx, tx, key)
+		if err != nil {
+			return err
+		}
+		fn(record)
+
+		encoded, err := encodeJSONRecord(record)
+		if err != nil {
+			return err
+		}
+		addressKey := s.addressKey(value)
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			// SET discards TTL, which is restored as well
+			pipe.Set(ctx, recordKey, encoded, 0)
+			if record.Expires.Equal(NeverExpire) {
+				pipe.Persist(ctx, addressKey)
+				pipe.ZRem(ctx, s.expiresKey(), value)
+			} else {
+				pipe.PExpireAt(ctx, recordKey, record.Expires.Add(redisExpiryGrace))
+				pipe.PExpireAt(ctx, addressKey, record.Expires.Add(redisExpiryGrace))
+				pipe.ZAdd(ctx, s.expiresKey(), &redis.Z{Score: float64(redisMillis(record.Expires)), Member: value})
+			}
+			return nil
+		})
+		return err
+	}, s.addressKey(value), s.expiresKey())
+	if err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+func (s *RedisStorage) CountDelivery(ctx context.Context, value string) (*Record, error) {
+	return s.update(ctx, value, func(record *Record) {
+		record.Deliveries++
+		record.UpdatedAt = time.Now()
+	})
+}
+
+func (s *RedisStorage) UpdateExpires(ctx context.Context, value string, expires time.Time) (*Record, error) {
+	return s.update(ctx, value, func(record *Record) {
+		record.Expires = expires
+		record.UpdatedAt = time.Now()
+	})
+}
+
+func (s *RedisStorage) UnsetExpired(ctx context.Context, until time.Time) ([]string, error) {
+	candidates, err := s.client.ZRangeByScore(ctx, s.expiresKey(), &redis.ZRangeBy{Min: "-inf", Max: "(" + strconv.FormatInt(redisMillis(until), 10)}).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to query expiry index: %w", err)
+	}
+
+	valuesExpired := []string{}
+	for _, value := range candidates {
+		expired := false
+		err := s.transact(ctx, func(tx *redis.Tx) error {
+			// may be consumed by another call in the meantime
+			score, err := tx.ZScore(ctx, s.expiresKey(), value).Result()
+			if errors.Is(err, redis.Nil) {
+				return nil
+			} else if err != nil {
+				return fmt.Errorf("failed to query expiry index: %w", err)
+			}
+			if int64(score) >= redisMillis(until) {
+				return nil
+			}
+
+			// may be already removed by TTL
+			key, err := tx.Get(ctx, s.addressKey(value)).Result()
+			if err != nil && !errors.Is(err, redis.Nil) {
+				return fmt.Errorf("failed to get address index: %w", err)
+			}
+			if key != "" {
+				if err := tx.Watch(ctx, s.recordKey(key)).Err(); err != nil {
+					return fmt.Errorf("failed to watch record: %w", err)
+				}
+			}
+
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				pipe.ZRem(ctx, s.expiresKey(), value)
+				if key != "" {
+					pipe.Del(ctx, s.recordKey(key), s.addressKey(value))
+					pipe.ZRem(ctx, s.keysKey(), key)
+				}
+				return nil
+			})
+			expired = err == nil
+			return err
+		}, s.expiresKey(), s.addressKey(value))
+		if err != nil {
+			return nil, fmt.Errorf("error occured while deleting record: %w", err)
+		}
+
+		if expired {
+			valuesExpired = append(valuesExpired, value)
+		}
+	}
+	return valuesExpired, nil
+}
+
+func (s *RedisStorage) List(ctx context.Context, cursor string, limit int) ([]*Record, string, error) {
+	if err := validateLimit(limit); err != nil {
+		return nil, "", err
+	}
+
+	min := "-"
+	if cursor != "" {
+		min = "(" + cursor
+	}
+
+	records := []*Record{}
+	vanished := []interface{}{}
+	for len(records) <= limit {
+		count := limit + 1 - len(records)
+		keys, err := s.client.ZRangeByLex(ctx, s.keysKey(), &redis.ZRangeBy{Min: min, Max: "+", Count: int64(count)}).Result()
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to query keys: %w", err)
+		}
+		if len(keys) == 0 {
+			break
+		}
+
+		recordKeys := make([]string, len(keys))
+		for i, key := range keys {
+			recordKeys[i] = s.recordKey(key)
+		}
+		encodeds, err := s.client.MGet(ctx, recordKeys...).Result()
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to get records: %w", err)
+		}
+
+		for i, encoded := range encodeds {
+			// removed by TTL
+			if encoded == nil {
+				vanished = append(vanished, keys[i])
+				continue
+			}
+
+			record, err := decodeJSONRecord(keys[i], []byte(encoded.(string)))
+			if err != nil {
+				return nil, "", err
+			}
+			records = append(records, record)
+		}
+
+		if len(keys) < count {
+			break
+		}
+		min = "(" + keys[len(keys)-1]
+	}
+
+	if len(vanished) > 0 {
+		if err := s.client.ZRem(ctx, s.keysKey(), vanished...).Err(); err != nil {
+			return nil, "", fmt.Errorf("failed to clean up keys: %w", err)
+		}
+	}
+
+	nextCursor := ""
+	if len(records) > limit {
+		records = records[:limit]
+		nextCursor = records[limit-1].Key
+	}
+	return records, nextCursor, nil
+}
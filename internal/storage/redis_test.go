@@ -0,0 +1,56 @@
+package storage_test
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/kaz/private-email-relay/internal/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedisExpiredByTTL(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mr.Close()
+
+	url := os.Getenv("REDIS_URL")
+	defer os.Setenv("REDIS_URL", url)
+	os.Setenv("REDIS_URL", "redis://"+mr.Addr())
+
+	s, err := storage.NewRedisStorage(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	key := "testRedisExpiredByTTL.test"
+	value := "testRedisExpiredByTTL@test.test"
+
+	err = s.Set(ctx, &storage.Record{Key: key, Value: value, Expires: now.Add(1 * time.Hour)})
+	assert.NoError(t, err)
+
+	mr.FastForward(30 * 24 * time.Hour)
+
+	_, err = s.Get(ctx, key)
+	assert.True(t, errors.Is(err, storage.ErrorUndefinedKey))
+
+	records, _, err := s.List(ctx, "", 10)
+	assert.NoError(t, err)
+	assert.Empty(t, records)
+
+	// address is not reusable until its expiry is reported
+	err = s.Set(ctx, &storage.Record{Key: key, Value: value, Expires: storage.NeverExpire})
+	assert.True(t, errors.Is(err, storage.ErrorDuplicatedValue))
+
+	expired, err := s.UnsetExpired(ctx, now.Add(2*time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{value}, expired)
+
+	err = s.Set(ctx, &storage.Record{Key: key, Value: value, Expires: storage.NeverExpire})
+	assert.NoError(t, err)
+}
@@ -0,0 +1,92 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+type (
+	// Factory creates a storage, which is configured by environment variables.
+	Factory func(ctx context.Context) (Storage, error)
+
+	// OpenOptions wraps the storage created by OpenWithOptions.
+	OpenOptions struct {
+		// encrypted by NewEncryptedStorage
+		Encrypted bool
+		// cached by NewCachedStorage, unless CacheSize is zero
+		CacheSize int
+		CacheTTL  time.Duration
+	}
+)
+
+var (
+	factories   = map[string]Factory{}
+	factoriesMu sync.RWMutex
+)
+
+func init() {
+	Register("firestore", NewFirestoreStorage)
+	Register("sqlite", NewSQLiteStorage)
+	Register("postgres", NewPostgresStorage)
+	Register("bolt", NewBoltStorage)
+	Register("redis", NewRedisStorage)
+	Register("memory", func(ctx context.Context) (Storage, error) {
+		return NewMemoryStorage(), nil
+	})
+}
+
+// Register makes a storage backend available by name. It panics if the name is already taken.
+func Register(name string, factory Factory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+
+	if _, ok := factories[name]; ok {
+		panic(fmt.Sprintf("storage backend is registered twice: %v", name))
+	}
+	factories[name] = factory
+}
+
+// Backends returns names of registered storage backends in order.
+func Backends() []string {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Open creates the storage registered as backend.
+func Open(ctx context.Context, backend string) (Storage, error) {
+	factoriesMu.RLock()
+	factory, ok := factories[backend]
+	factoriesMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown storage backend: %q (available: %v)", backend, Backends())
+	}
+	return factory(ctx)
+}
+
+// OpenWithOptions creates the storage registered as backend, and wraps it as opts specifies.
+func OpenWithOptions(ctx context.Context, backend string, opts OpenOptions) (Storage, error) {
+	store, err := Open(ctx, backend)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Encrypted {
+		if store, err = NewEncryptedStorage(store); err != nil {
+			return nil, fmt.Errorf("failed to initialize encryption: %w", err)
+		}
+	}
+	if opts.CacheSize > 0 {
+		store = NewCachedStorage(store, opts.CacheSize, opts.CacheTTL)
+	}
+	return store, nil
+}
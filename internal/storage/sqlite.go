@@ -0,0 +1,326 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+type (
+	SQLiteStorage struct {
+		db *sql.DB
+	}
+
+	rowScanner interface {
+		Scan(dest ...interface{}) error
+	}
+)
+
+var (
+	// applied in order by PRAGMA user_version, and never modified once released.
+	// the first one is idempotent, since databases created before versioning have user_version 0.
+	sqliteMigrations = []string{
+		`
+CREATE TABLE IF NOT EXISTS relays (
+	key        TEXT    NOT NULL PRIMARY KEY,
+	address    TEXT    NOT NULL UNIQUE,
+	expires    INTEGER NOT NULL,
+	label      TEXT    NOT NULL DEFAULT '',
+	note       TEXT    NOT NULL DEFAULT '',
+	tags       TEXT    NOT NULL DEFAULT '[]',
+	created_at INTEGER NOT NULL,
+	updated_at INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS relays_expires ON relays (expires);
+`,
+		`
+ALTER TABLE relays ADD COLUMN deliveries INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE relays ADD COLUMN max_deliveries INTEGER NOT NULL DEFAULT 0;
+`,
+	}
+)
+
+const (
+	sqliteColumns = "key, address, expires, deliveries, max_deliveries, label, note, tags, created_at, updated_at"
+)
+
+func NewSQLiteStorage(ctx context.Context) (Storage, error) {
+	path := os.Getenv("SQLITE_PATH")
+	if path == "" {
+		return nil, fmt.Errorf("SQLITE_PATH is missing")
+	}
+
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open SQLite database: %w", err)
+	}
+
+	// SQLite allows only one writer at once, and each connection to ":memory:" has its own database.
+	db.SetMaxOpenConns(1)
+
+	if err := migrateSQLite(ctx, db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to migrate schema: %w", err)
+	}
+
+	return &SQLiteStorage{db}, nil
+}
+
+func migrateSQLite(ctx context.Context, db *sql.DB) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var version int
+	if err := tx.QueryRowContext(ctx, "PRAGMA user_version").Scan(&version); err != nil {
+		return fmt.Errorf("failed to determine schema version: %w", err)
+	}
+
+	for ; version < len(sqliteMigrations); version++ {
+		if _, err := tx.ExecContext(ctx, sqliteMigrations[version]); err != nil {
+			return fmt.Errorf("failed to apply migration #%d: %w", version+1, err)
+		}
+	}
+	// PRAGMA does not accept placeholders
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf("PRAGMA user_version = %d", version)); err != nil {
+		return fmt.Errorf("failed to record schema version: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit: %w", err)
+	}
+	return nil
+}
+
+// timestamps are stored in microseconds, since NeverExpire overflows nanoseconds
+func toMicros(t time.Time) int64 {
+	return t.Unix()*1e6 + int64(t.Nanosecond())/1e3
+}
+func fromMicros(micros int64) time.Time {
+	sec, usec := micros/1e6, micros%1e6
+	if usec < 0 {
+		sec, usec = sec-1, usec+1e6
+	}
+	return time.Unix(sec, usec*1e3)
+}
+
+func scanSQLiteRecord(row rowScanner) (*Record, error) {
+	record := &Record{}
+	var expires, createdAt, updatedAt int64
+	var tags string
+
+	if err := row.Scan(&record.Key, &record.Value, &expires, &record.Deliveries, &record.MaxDeliveries, &record.Label, &record.Note, &tags, &createdAt, &updatedAt); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(tags), &record.Tags); err != nil {
+		return nil, fmt.Errorf("failed to decode tags: %w", err)
+	}
+	if len(record.Tags) == 0 {
+		record.Tags = nil
+	}
+
+	record.Expires = fromMicros(expires)
+	record.CreatedAt = fromMicros(createdAt)
+	record.UpdatedAt = fromMicros(updatedAt)
+	return record, nil
+}
+
+func encodeTags(tags []string) (string, error) {
+	if tags == nil {
+		tags = []string{}
+	}
+	encoded, err := json.Marshal(tags)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode tags: %w", err)
+	}
+	return string(encoded), nil
+}
+
+func (s *SQLiteStorage) Get(ctx context.Context, key string) (*Record, error) {
+	record, err := scanSQLiteRecord(s.db.QueryRowContext(ctx, "SELECT "+sqliteColumns+" FROM relays WHERE key = ?", key))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%w: key=%v", ErrorUndefinedKey, key)
+		}
+		return nil, fmt.Errorf("failed to query: %w", err)
+	}
+	return record, nil
+}
+
+func (s *SQLiteStorage) GetByValue(ctx context.Context, value string) (*Record, error) {
+	record, err := scanSQLiteRecord(s.db.QueryRowContext(ctx, "SELECT "+sqliteColumns+" FROM relays WHERE address = ?", value))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%w: value=%v", ErrorUndefinedValue, value)
+		}
+		return nil, fmt.Errorf("failed to query: %w", err)
+	}
+	return record, nil
+}
+
+func (s *SQLiteStorage) Set(ctx context.Context, record *Record) error {
+	tags, err := encodeTags(record.Tags)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.ExecContext(ctx,
+		"INSERT INTO relays ("+sqliteColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		record.Key, record.Value, toMicros(record.Expires), record.Deliveries, record.MaxDeliveries, record.Label, record.Note, tags, toMicros(record.CreatedAt), toMicros(record.UpdatedAt),
+	)
+	if err != nil {
+		var sqliteErr *sqlite.Error
+		if errors.As(err, &sqliteErr) {
+			switch sqliteErr.Code() {
+			case sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY:
+				return fmt.Errorf("%w: key=%v", ErrorDuplicatedKey, record.Key)
+			case sqlite3.SQLITE_CONSTRAINT_UNIQUE:
+				return fmt.Errorf("%w: value=%v", ErrorDuplicatedValue, record.Value)
+			}
+		}
+		return fmt.Errorf("failed to insert: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLiteStorage) unset(ctx context.Context, column, needle string, undefined error) (*Record, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	record, err := scanSQLiteRecord(tx.QueryRowContext(ctx, "SELECT "+sqliteColumns+" FROM relays WHERE "+column+" = ?", needle))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%w: %v=%v", undefined, column, needle)
+		}
+		return nil, fmt.Errorf("failed to query: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM relays WHERE key = ?", record.Key); err != nil {
+		return nil, fmt.Errorf("failed to delete: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit: %w", err)
+	}
+	return record, nil
+}
+
+func (s *SQLiteStorage) UnsetByKey(ctx context.Context, key string) (*Record, error) {
+	return s.unset(ctx, "key", key, ErrorUndefinedKey)
+}
+
+func (s *SQLiteStorage) UnsetByValue(ctx context.Context, value string) (*Record, error) {
+	return s.unset(ctx, "address", value, ErrorUndefinedValue)
+}
+
+// update applies assignments, such as "expires = ?", to the record of value.
+func (s *SQLiteStorage) update(ctx context.Context, value string, assignments string, args ...interface{}) (*Record, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, "UPDATE relays SET "+assignments+" WHERE address = ?", append(args, value)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update: %w", err)
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return nil, fmt.Errorf("failed to update: %w", err)
+	} else if n == 0 {
+		return nil, fmt.Errorf("%w: value=%v", ErrorUndefinedValue, value)
+	}
+
+	record, err := scanSQLiteRecord(tx.QueryRowContext(ctx, "SELECT "+sqliteColumns+" FROM relays WHERE address = ?", value))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit: %w", err)
+	}
+	return record, nil
+}
+
+func (s *SQLiteStorage) CountDelivery(ctx context.Context, value string) (*Record, error) {
+	return s.update(ctx, value, "deliveries = deliveries + 1, updated_at = ?", toMicros(time.Now()))
+}
+
+func (s *SQLiteStorage) UpdateExpires(ctx context.Context, value string, expires time.Time) (*Record, error) {
+	return s.update(ctx, value, "expires = ?, updated_at = ?", toMicros(expires), toMicros(time.Now()))
+}
+
+func (s *SQLiteStorage) UnsetExpired(ctx context.Context, until time.Time) ([]string, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, "SELECT address FROM relays WHERE expires < ?", toMicros(until))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query: %w", err)
+	}
+	defer rows.Close()
+
+	valuesExpired := []string{}
+	for rows.Next() {
+		var value string
+		if err := rows.Scan(&value); err != nil {
+			return nil, fmt.Errorf("failed to read row: %w", err)
+		}
+		valuesExpired = append(valuesExpired, value)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read rows: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM relays WHERE expires < ?", toMicros(until)); err != nil {
+		return nil, fmt.Errorf("failed to delete: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit: %w", err)
+	}
+	return valuesExpired, nil
+}
+
+func (s *SQLiteStorage) List(ctx context.Context, cursor string, limit int) ([]*Record, string, error) {
+	if err := validateLimit(limit); err != nil {
+		return nil, "", err
+	}
+
+	rows, err := s.db.QueryContext(ctx, "SELECT "+sqliteColumns+" FROM relays WHERE key > ? ORDER BY key LIMIT ?", cursor, limit+1)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to query: %w", err)
+	}
+	defer rows.Close()
+
+	records := []*Record{}
+	for rows.Next() {
+		record, err := scanSQLiteRecord(rows)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to read row: %w", err)
+		}
+		records = append(records, record)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", fmt.Errorf("failed to read rows: %w", err)
+	}
+
+	nextCursor := ""
+	if len(records) > limit {
+		records = records[:limit]
+		nextCursor = records[limit-1].Key
+	}
+	return records, nextCursor, nil
+}
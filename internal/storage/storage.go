@@ -9,15 +9,48 @@ import (
 type (
 	Storage interface {
 		// returns ErrorUndefinedKey
-		Get(ctx context.Context, key string) (value string, err error)
+		Get(ctx context.Context, key string) (record *Record, err error)
+		// returns ErrorUndefinedValue
+		GetByValue(ctx context.Context, value string) (record *Record, err error)
 		// returns ErrorDuplicatedKey, ErrorDuplicatedValue
-		Set(ctx context.Context, key, value string, expires time.Time) (err error)
+		Set(ctx context.Context, record *Record) (err error)
 		// returns ErrorUndefinedKey
-		UnsetByKey(ctx context.Context, key string) (deletedValue string, err error)
+		UnsetByKey(ctx context.Context, key string) (deletedRecord *Record, err error)
+		// returns ErrorUndefinedValue
+		UnsetByValue(ctx context.Context, value string) (deletedRecord *Record, err error)
 		// returns ErrorUndefinedValue
-		UnsetByValue(ctx context.Context, value string) (deletedValue string, err error)
+		// increments Deliveries of the record of `value`, and returns the updated record with UpdatedAt of now.
+		CountDelivery(ctx context.Context, value string) (record *Record, err error)
+		// returns ErrorUndefinedValue
+		// replaces Expires of the record of `value`, which may be NeverExpire, and returns the updated record with UpdatedAt of now.
+		UpdateExpires(ctx context.Context, value string, expires time.Time) (record *Record, err error)
 		// returns [Nothing]
 		UnsetExpired(ctx context.Context, until time.Time) (deletedValues []string, err error)
+		// returns ErrorInvalidLimit
+		// records are listed in a stable order, which is not necessarily of keys, starting after `cursor` of the last record listed.
+		// `nextCursor` is empty when there are no more records.
+		List(ctx context.Context, cursor string, limit int) (records []*Record, nextCursor string, err error)
+	}
+
+	Record struct {
+		Key     string
+		Value   string
+		Expires time.Time
+
+		// number of messages delivered to Value
+		Deliveries int
+		// Value is unassigned once Deliveries reaches it, unless zero
+		MaxDeliveries int
+
+		Metadata
+
+		CreatedAt time.Time
+		UpdatedAt time.Time
+	}
+	Metadata struct {
+		Label string
+		Note  string
+		Tags  []string
 	}
 )
 
@@ -26,6 +59,23 @@ var (
 	ErrorUndefinedValue  = fmt.Errorf("undefined value")
 	ErrorDuplicatedKey   = fmt.Errorf("duplicated key")
 	ErrorDuplicatedValue = fmt.Errorf("duplicated value")
+	ErrorInvalidLimit    = fmt.Errorf("invalid limit")
 
 	NeverExpire = time.Date(9999, 1, 1, 0, 0, 0, 0, time.UTC)
 )
+
+// validateLimit rejects limits of List, with which no page can be listed.
+func validateLimit(limit int) error {
+	if limit <= 0 {
+		return fmt.Errorf("%w: limit=%v", ErrorInvalidLimit, limit)
+	}
+	return nil
+}
+
+func (r *Record) clone() *Record {
+	cloned := *r
+	if r.Tags != nil {
+		cloned.Tags = append([]string{}, r.Tags...)
+	}
+	return &cloned
+}
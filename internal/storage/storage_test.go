@@ -4,9 +4,13 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/alicebob/miniredis/v2"
 	"github.com/kaz/private-email-relay/internal/storage"
 	"github.com/stretchr/testify/assert"
 )
@@ -29,6 +33,7 @@ func TestMain(m *testing.M) {
 	var err error
 
 	implements["memory"] = storage.NewMemoryStorage()
+	implements["cached"] = storage.NewCachedStorage(storage.NewMemoryStorage(), 1000, time.Minute)
 
 	implements["firestore"], err = storage.NewFirestoreStorage(ctx)
 	if err != nil {
@@ -36,6 +41,52 @@ func TestMain(m *testing.M) {
 		delete(implements, "firestore")
 	}
 
+	implements["postgres"], err = storage.NewPostgresStorage(ctx)
+	if err != nil {
+		fmt.Printf("[[WARNING]] skip postgres: %v", err)
+		delete(implements, "postgres")
+	}
+
+	if os.Getenv("REDIS_URL") == "" {
+		mr, err := miniredis.Run()
+		if err != nil {
+			panic(err)
+		}
+		defer mr.Close()
+
+		os.Setenv("REDIS_URL", "redis://"+mr.Addr())
+	}
+	implements["redis"], err = storage.NewRedisStorage(ctx)
+	if err != nil {
+		fmt.Printf("[[WARNING]] skip redis: %v", err)
+		delete(implements, "redis")
+	}
+
+	// embedded databases are always tested
+	dir, err := os.MkdirTemp("", "storage_test")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if os.Getenv("SQLITE_PATH") == "" {
+		os.Setenv("SQLITE_PATH", filepath.Join(dir, "relay.sqlite"))
+	}
+	implements["sqlite"], err = storage.NewSQLiteStorage(ctx)
+	if err != nil {
+		fmt.Printf("[[WARNING]] skip sqlite: %v", err)
+		delete(implements, "sqlite")
+	}
+
+	if os.Getenv("BOLT_PATH") == "" {
+		os.Setenv("BOLT_PATH", filepath.Join(dir, "relay.bolt"))
+	}
+	implements["bolt"], err = storage.NewBoltStorage(ctx)
+	if err != nil {
+		fmt.Printf("[[WARNING]] skip bolt: %v", err)
+		delete(implements, "bolt")
+	}
+
 	testCases := []testCase{
 		{
 			key:     "dummy0.test",
@@ -56,7 +107,7 @@ func TestMain(m *testing.M) {
 
 	for _, impl := range implements {
 		for _, testCase := range testCases {
-			if err := impl.Set(ctx, testCase.key, testCase.value, testCase.expires); err != nil {
+			if err := impl.Set(ctx, &storage.Record{Key: testCase.key, Value: testCase.value, Expires: testCase.expires}); err != nil {
 				fmt.Printf("[[WARNING]] failed fill dummy: %v", err)
 			}
 		}
@@ -85,16 +136,83 @@ func testSetAndGet(t *testing.T, s storage.Storage) {
 	key := "testSetAndGet.test"
 	value := "testSetAndGet@test.test"
 
-	err := s.Set(ctx, key, value, storage.NeverExpire)
+	err := s.Set(ctx, &storage.Record{Key: key, Value: value, Expires: storage.NeverExpire})
 	assert.NoError(t, err)
 
 	got, err := s.Get(ctx, key)
 	assert.NoError(t, err)
-	assert.Equal(t, value, got)
+	assert.Equal(t, value, got.Value)
+
+	// cleanup
+	_, err = s.UnsetByKey(ctx, key)
+	assert.NoError(t, err)
+}
+
+func TestGetByValue(t *testing.T) {
+	for name, impl := range implements {
+		t.Run(name, func(t *testing.T) {
+			testGetByValue(t, impl)
+		})
+	}
+}
+func testGetByValue(t *testing.T, s storage.Storage) {
+	key := "testGetByValue.test"
+	value := "testGetByValue@test.test"
+
+	err := s.Set(ctx, &storage.Record{Key: key, Value: value, Expires: storage.NeverExpire})
+	assert.NoError(t, err)
+
+	got, err := s.GetByValue(ctx, value)
+	assert.NoError(t, err)
+	assert.Equal(t, key, got.Key)
 
 	// cleanup
 	_, err = s.UnsetByKey(ctx, key)
 	assert.NoError(t, err)
+
+	_, err = s.GetByValue(ctx, value)
+	assert.True(t, errors.Is(err, storage.ErrorUndefinedValue))
+}
+
+func TestSetAndGetMetadata(t *testing.T) {
+	for name, impl := range implements {
+		t.Run(name, func(t *testing.T) {
+			testSetAndGetMetadata(t, impl)
+		})
+	}
+}
+func testSetAndGetMetadata(t *testing.T, s storage.Storage) {
+	now := time.Now().Truncate(time.Second)
+
+	record := &storage.Record{
+		Key:     "testSetAndGetMetadata.test",
+		Value:   "testSetAndGetMetadata@test.test",
+		Expires: storage.NeverExpire,
+		Metadata: storage.Metadata{
+			Label: "label",
+			Note:  "note",
+			Tags:  []string{"tag0", "tag1"},
+		},
+		CreatedAt: now.Add(-1 * time.Hour),
+		UpdatedAt: now,
+	}
+
+	err := s.Set(ctx, record)
+	assert.NoError(t, err)
+
+	got, err := s.Get(ctx, record.Key)
+	assert.NoError(t, err)
+	assert.Equal(t, record.Key, got.Key)
+	assert.Equal(t, record.Value, got.Value)
+	assert.Equal(t, record.Metadata, got.Metadata)
+	assert.True(t, record.Expires.Equal(got.Expires))
+	assert.True(t, record.CreatedAt.Equal(got.CreatedAt))
+	assert.True(t, record.UpdatedAt.Equal(got.UpdatedAt))
+
+	// cleanup
+	deleted, err := s.UnsetByKey(ctx, record.Key)
+	assert.NoError(t, err)
+	assert.Equal(t, record.Metadata, deleted.Metadata)
 }
 
 func TestUnsetByKey(t *testing.T) {
@@ -108,12 +226,12 @@ func testUnsetByKey(t *testing.T, s storage.Storage) {
 	key := "testUnsetByKey.test"
 	value := "testUnsetByKey@test.test"
 
-	err := s.Set(ctx, key, value, storage.NeverExpire)
+	err := s.Set(ctx, &storage.Record{Key: key, Value: value, Expires: storage.NeverExpire})
 	assert.NoError(t, err)
 
 	deleted, err := s.UnsetByKey(ctx, key)
 	assert.NoError(t, err)
-	assert.Equal(t, value, deleted)
+	assert.Equal(t, value, deleted.Value)
 
 	_, err = s.Get(ctx, key)
 	assert.Error(t, err)
@@ -131,12 +249,12 @@ func testUnsetByValue(t *testing.T, s storage.Storage) {
 	key := "testUnsetByKey.test"
 	value := "testUnsetByValue@test.test"
 
-	err := s.Set(ctx, key, value, storage.NeverExpire)
+	err := s.Set(ctx, &storage.Record{Key: key, Value: value, Expires: storage.NeverExpire})
 	assert.NoError(t, err)
 
 	deleted, err := s.UnsetByValue(ctx, value)
 	assert.NoError(t, err)
-	assert.Equal(t, value, deleted)
+	assert.Equal(t, value, deleted.Value)
 
 	_, err = s.Get(ctx, key)
 	assert.Error(t, err)
@@ -194,7 +312,7 @@ func testUnsetExpired(t *testing.T, s storage.Storage) {
 
 	expectDeleted := []string{}
 	for _, testCase := range testCases {
-		err := s.Set(ctx, testCase.key, testCase.value, testCase.expires)
+		err := s.Set(ctx, &storage.Record{Key: testCase.key, Value: testCase.value, Expires: testCase.expires})
 		assert.NoError(t, err)
 
 		if testCase.deleted {
@@ -214,7 +332,7 @@ func testUnsetExpired(t *testing.T, s storage.Storage) {
 		} else {
 			got, err := s.Get(ctx, testCase.key)
 			assert.NoError(t, err)
-			assert.Equal(t, testCase.value, got)
+			assert.Equal(t, testCase.value, got.Value)
 
 			// cleanup
 			_, err = s.UnsetByKey(ctx, testCase.key)
@@ -252,10 +370,10 @@ func testSetDuplicatedKey(t *testing.T, s storage.Storage) {
 		"testSetDuplicatedKey-1@test.test",
 	}
 
-	err := s.Set(ctx, key, values[0], storage.NeverExpire)
+	err := s.Set(ctx, &storage.Record{Key: key, Value: values[0], Expires: storage.NeverExpire})
 	assert.NoError(t, err)
 
-	err = s.Set(ctx, key, values[1], storage.NeverExpire)
+	err = s.Set(ctx, &storage.Record{Key: key, Value: values[1], Expires: storage.NeverExpire})
 	assert.Error(t, err)
 	assert.True(t, errors.Is(err, storage.ErrorDuplicatedKey))
 
@@ -278,10 +396,10 @@ func testSetDuplicatedValue(t *testing.T, s storage.Storage) {
 	}
 	value := "testSetDuplicatedValue@test.test"
 
-	err := s.Set(ctx, keys[0], value, storage.NeverExpire)
+	err := s.Set(ctx, &storage.Record{Key: keys[0], Value: value, Expires: storage.NeverExpire})
 	assert.NoError(t, err)
 
-	err = s.Set(ctx, keys[1], value, storage.NeverExpire)
+	err = s.Set(ctx, &storage.Record{Key: keys[1], Value: value, Expires: storage.NeverExpire})
 	assert.Error(t, err)
 	assert.True(t, errors.Is(err, storage.ErrorDuplicatedValue))
 
@@ -319,3 +437,282 @@ func testUnsetUndefinedValue(t *testing.T, s storage.Storage) {
 	assert.Error(t, err)
 	assert.True(t, errors.Is(err, storage.ErrorUndefinedValue))
 }
+
+func TestCountDelivery(t *testing.T) {
+	for name, impl := range implements {
+		t.Run(name, func(t *testing.T) {
+			testCountDelivery(t, impl)
+		})
+	}
+}
+func testCountDelivery(t *testing.T, s storage.Storage) {
+	record := &storage.Record{
+		Key:           "testCountDelivery.test",
+		Value:         "testCountDelivery@test.test",
+		Expires:       time.Now().Add(time.Hour),
+		MaxDeliveries: 3,
+		UpdatedAt:     time.Now().Add(-time.Hour),
+	}
+
+	err := s.Set(ctx, record)
+	assert.NoError(t, err)
+
+	for i := 1; i <= 2; i++ {
+		counted, err := s.CountDelivery(ctx, record.Value)
+		assert.NoError(t, err)
+		assert.Equal(t, record.Key, counted.Key)
+		assert.Equal(t, i, counted.Deliveries)
+		assert.Equal(t, 3, counted.MaxDeliveries)
+		assert.True(t, counted.UpdatedAt.After(record.UpdatedAt))
+	}
+
+	got, err := s.Get(ctx, record.Key)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, got.Deliveries)
+	assert.Equal(t, 3, got.MaxDeliveries)
+	assert.True(t, got.UpdatedAt.After(record.UpdatedAt))
+	assert.True(t, record.Expires.Truncate(time.Millisecond).Equal(got.Expires.Truncate(time.Millisecond)))
+
+	_, err = s.CountDelivery(ctx, "testCountDeliveryUndefined@test.test")
+	assert.Error(t, err)
+	assert.True(t, errors.Is(err, storage.ErrorUndefinedValue))
+
+	// cleanup
+	_, err = s.UnsetByKey(ctx, record.Key)
+	assert.NoError(t, err)
+}
+
+func TestUpdateExpires(t *testing.T) {
+	for name, impl := range implements {
+		t.Run(name, func(t *testing.T) {
+			testUpdateExpires(t, impl)
+		})
+	}
+}
+func testUpdateExpires(t *testing.T, s storage.Storage) {
+	now := time.Now()
+	record := &storage.Record{
+		Key:       "testUpdateExpires.test",
+		Value:     "testUpdateExpires@test.test",
+		Expires:   now.Add(time.Hour),
+		Metadata:  storage.Metadata{Label: "label"},
+		CreatedAt: now.Add(-time.Hour),
+		UpdatedAt: now.Add(-time.Hour),
+	}
+
+	err := s.Set(ctx, record)
+	assert.NoError(t, err)
+
+	// made permanent, and not expired any more
+	updated, err := s.UpdateExpires(ctx, record.Value, storage.NeverExpire)
+	assert.NoError(t, err)
+	assert.Equal(t, record.Key, updated.Key)
+	assert.Equal(t, record.Metadata, updated.Metadata)
+	assert.True(t, storage.NeverExpire.Equal(updated.Expires))
+	assert.True(t, updated.UpdatedAt.After(record.UpdatedAt))
+
+	got, err := s.Get(ctx, record.Key)
+	assert.NoError(t, err)
+	assert.True(t, storage.NeverExpire.Equal(got.Expires))
+
+	expired, err := s.UnsetExpired(ctx, now.Add(2*time.Hour))
+	assert.NoError(t, err)
+	assert.NotContains(t, expired, record.Value)
+
+	// made temporary again
+	deadline := now.Add(30 * time.Minute).Truncate(time.Millisecond)
+	updated, err = s.UpdateExpires(ctx, record.Value, deadline)
+	assert.NoError(t, err)
+	assert.True(t, deadline.Equal(updated.Expires))
+
+	got, err = s.Get(ctx, record.Key)
+	assert.NoError(t, err)
+	assert.True(t, deadline.Equal(got.Expires))
+
+	expired, err = s.UnsetExpired(ctx, now.Add(2*time.Hour))
+	assert.NoError(t, err)
+	assert.Contains(t, expired, record.Value)
+
+	_, err = s.UpdateExpires(ctx, record.Value, storage.NeverExpire)
+	assert.Error(t, err)
+	assert.True(t, errors.Is(err, storage.ErrorUndefinedValue))
+}
+
+func TestList(t *testing.T) {
+	for name, impl := range implements {
+		t.Run(name, func(t *testing.T) {
+			testList(t, impl)
+		})
+	}
+}
+func testList(t *testing.T, s storage.Storage) {
+	now := time.Now().Truncate(time.Second)
+
+	testCases := []testCase{
+		{
+			key:     "testList-0.test",
+			value:   "testList-0@test.test",
+			expires: storage.NeverExpire,
+		},
+		{
+			key:     "testList-1.test",
+			value:   "testList-1@test.test",
+			expires: now.Add(1 * time.Hour),
+		},
+		{
+			key:     "testList-2.test",
+			value:   "testList-2@test.test",
+			expires: now.Add(24 * time.Hour),
+		},
+		// escaped by some backends, which may list them out of order of keys
+		{
+			key:     "testList-3.test/path",
+			value:   "testList-3@test.test",
+			expires: storage.NeverExpire,
+		},
+		{
+			key:     "testList-3.test%2Fpath",
+			value:   "testList-4@test.test",
+			expires: storage.NeverExpire,
+		},
+	}
+
+	for _, testCase := range testCases {
+		err := s.Set(ctx, &storage.Record{Key: testCase.key, Value: testCase.value, Expires: testCase.expires})
+		assert.NoError(t, err)
+	}
+
+	// storage may contain entries created by other test
+	listed := map[string]*storage.Record{}
+	for cursor, pages := "", 0; pages == 0 || cursor != ""; pages++ {
+		records, nextCursor, err := s.List(ctx, cursor, 2)
+		assert.NoError(t, err)
+		assert.LessOrEqual(t, len(records), 2)
+
+		// not necessarily ordered by key, but never listed twice
+		for _, record := range records {
+			assert.NotContains(t, listed, record.Key)
+			listed[record.Key] = record
+		}
+		cursor = nextCursor
+	}
+
+	for _, testCase := range testCases {
+		record, ok := listed[testCase.key]
+		if assert.True(t, ok) {
+			assert.Equal(t, testCase.value, record.Value)
+			assert.True(t, testCase.expires.Equal(record.Expires))
+		}
+
+		// cleanup
+		_, err := s.UnsetByKey(ctx, testCase.key)
+		assert.NoError(t, err)
+	}
+}
+
+func TestListInvalidLimit(t *testing.T) {
+	for name, impl := range implements {
+		t.Run(name, func(t *testing.T) {
+			testListInvalidLimit(t, impl)
+		})
+	}
+}
+func testListInvalidLimit(t *testing.T, s storage.Storage) {
+	err := s.Set(ctx, &storage.Record{Key: "testListInvalidLimit.test", Value: "testListInvalidLimit@test.test", Expires: storage.NeverExpire})
+	assert.NoError(t, err)
+
+	for _, limit := range []int{0, -1} {
+		_, _, err := s.List(ctx, "", limit)
+		assert.True(t, errors.Is(err, storage.ErrorInvalidLimit), "limit=%v", limit)
+	}
+
+	// cleanup
+	_, err = s.UnsetByKey(ctx, "testListInvalidLimit.test")
+	assert.NoError(t, err)
+}
+
+func TestSetConcurrently(t *testing.T) {
+	for name, impl := range implements {
+		t.Run(name, func(t *testing.T) {
+			testSetConcurrently(t, impl)
+		})
+	}
+}
+func testSetConcurrently(t *testing.T, s storage.Storage) {
+	concurrency := 8
+	value := "testSetConcurrently@test.test"
+
+	errs := make([]error, concurrency)
+	wg := sync.WaitGroup{}
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = s.Set(ctx, &storage.Record{Key: fmt.Sprintf("testSetConcurrently-%d.test", i), Value: value, Expires: storage.NeverExpire})
+		}(i)
+	}
+	wg.Wait()
+
+	succeeded := 0
+	for _, err := range errs {
+		if err == nil {
+			succeeded++
+		} else {
+			assert.True(t, errors.Is(err, storage.ErrorDuplicatedValue))
+		}
+	}
+	assert.Equal(t, 1, succeeded)
+
+	// cleanup
+	_, err := s.UnsetByValue(ctx, value)
+	assert.NoError(t, err)
+}
+
+func TestUnsetConcurrently(t *testing.T) {
+	for name, impl := range implements {
+		t.Run(name, func(t *testing.T) {
+			testUnsetConcurrently(t, impl)
+		})
+	}
+}
+func testUnsetConcurrently(t *testing.T, s storage.Storage) {
+	concurrency := 8
+	key := "testUnsetConcurrently.test"
+	value := "testUnsetConcurrently@test.test"
+
+	err := s.Set(ctx, &storage.Record{Key: key, Value: value, Expires: storage.NeverExpire})
+	assert.NoError(t, err)
+
+	errs := make([]error, concurrency)
+	wg := sync.WaitGroup{}
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if i%2 == 0 {
+				_, errs[i] = s.UnsetByKey(ctx, key)
+			} else {
+				_, errs[i] = s.UnsetByValue(ctx, value)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	succeeded := 0
+	for _, err := range errs {
+		if err == nil {
+			succeeded++
+		} else {
+			assert.True(t, errors.Is(err, storage.ErrorUndefinedKey) || errors.Is(err, storage.ErrorUndefinedValue))
+		}
+	}
+	assert.Equal(t, 1, succeeded)
+
+	// address can be reused after deletion
+	err = s.Set(ctx, &storage.Record{Key: key, Value: value, Expires: storage.NeverExpire})
+	assert.NoError(t, err)
+
+	// cleanup
+	_, err = s.UnsetByKey(ctx, key)
+	assert.NoError(t, err)
+}
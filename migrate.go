@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/kaz/private-email-relay/internal/storage"
+)
+
+// usage: private-email-relay migrate --from firestore --to sqlite [--checkpoint FILE] [--decrypt-from] [--encrypt-to]
+// both backends are configured by the same environment variables as the server.
+// --decrypt-from and --encrypt-to use the key in STORAGE_ENCRYPTION_KEY or STORAGE_ENCRYPTION_KEY_FILE.
+func migrate(args []string) error {
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	from := flags.String("from", "", "source storage backend")
+	to := flags.String("to", "", "destination storage backend")
+	checkpoint := flags.String("checkpoint", "", "file to save progress to, and resume from if it exists")
+	pageSize := flags.Int("page-size", 100, "number of records read at once")
+	decryptFrom := flags.Bool("decrypt-from", false, "source storage is encrypted")
+	encryptTo := flags.Bool("encrypt-to", false, "encrypt records written to destination storage")
+	flags.Parse(args)
+
+	if *from == "" || *to == "" {
+		return fmt.Errorf("--from and --to are required")
+	}
+	if *from == *to {
+		return fmt.Errorf("--from and --to must be different backends")
+	}
+
+	ctx := context.Background()
+
+	src, err := storage.OpenWithOptions(ctx, *from, storage.OpenOptions{Encrypted: *decryptFrom})
+	if err != nil {
+		return fmt.Errorf("failed to open source: %w", err)
+	}
+	dst, err := storage.OpenWithOptions(ctx, *to, storage.OpenOptions{Encrypted: *encryptTo})
+	if err != nil {
+		return fmt.Errorf("failed to open destination: %w", err)
+	}
+
+	opts := storage.MigrateOptions{PageSize: *pageSize}
+	if *checkpoint != "" {
+		cursor, err := os.ReadFile(*checkpoint)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to read checkpoint: %w", err)
+		}
+		opts.Cursor = strings.TrimSpace(string(cursor))
+		if opts.Cursor != "" {
+			fmt.Printf("resuming after %v\n", opts.Cursor)
+		}
+
+		opts.Checkpoint = func(cursor string) error {
+			return os.WriteFile(*checkpoint, []byte(cursor+"\n"), 0600)
+		}
+	}
+
+	report, err := storage.Migrate(ctx, src, dst, opts)
+	if report != nil {
+		fmt.Printf("migrated: %d, skipped: %d, conflicts: %d\n", len(report.Migrated), len(report.Skipped), len(report.Conflicts))
+		for _, conflict := range report.Conflicts {
+			fmt.Printf("conflict: %v\n", conflict)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("migration interrupted: %w", err)
+	}
+	if len(report.Conflicts) > 0 {
+		return fmt.Errorf("%d records are not migrated due to conflicts", len(report.Conflicts))
+	}
+
+	if *checkpoint != "" {
+		if err := os.Remove(*checkpoint); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove checkpoint: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/kaz/private-email-relay/internal/assign"
+	"github.com/kaz/private-email-relay/internal/config"
+	"github.com/kaz/private-email-relay/internal/router"
+)
+
+// usage: private-email-relay recover --strategy NAME [--dry-run] [FILE]
+// URLs are read from FILE, or stdin if omitted, one per line.
+// storage, router and the strategy are configured in the same way as the server.
+func recoverMappings(args []string) error {
+	flags := flag.NewFlagSet("recover", flag.ExitOnError)
+	name := flags.String("strategy", "", "name of deterministic strategy in configuration")
+	dryRun := flags.Bool("dry-run", false, "report what would be restored without writing to storage")
+	flags.Parse(args)
+
+	if *name == "" {
+		return fmt.Errorf("--strategy is required")
+	}
+
+	input := io.Reader(os.Stdin)
+	if flags.NArg() > 0 {
+		file, err := os.Open(flags.Arg(0))
+		if err != nil {
+			return fmt.Errorf("failed to open URL list: %w", err)
+		}
+		defer file.Close()
+		input = file
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+	strategyCfg, ok := cfg.Strategies[*name]
+	if !ok {
+		return fmt.Errorf("no such strategy: %v", *name)
+	}
+
+	ctx := context.Background()
+
+	store, err := cfg.Storage.Open(ctx)
+	if err != nil {
+		return err
+	}
+	route, err := router.Open(cfg.Router.Backend)
+	if err != nil {
+		return fmt.Errorf("failed to open router %v: %w", cfg.Router.Backend, err)
+	}
+
+	strategy, err := assign.New(strategyCfg.Type, store, route, strategyCfg.Params)
+	if err != nil {
+		return fmt.Errorf("failed to initialize strategy %v: %w", *name, err)
+	}
+	deterministic, ok := strategy.(*assign.DeterministicStrategy)
+	if !ok {
+		return fmt.Errorf("strategy %v is not deterministic: %v", *name, strategyCfg.Type)
+	}
+
+	counts := map[assign.RecoverResult]int{}
+	failed := 0
+
+	scanner := bufio.NewScanner(input)
+	for scanner.Scan() {
+		url := strings.TrimSpace(scanner.Text())
+		if url == "" || strings.HasPrefix(url, "#") {
+			continue
+		}
+
+		result, err := deterministic.Recover(ctx, url, *dryRun)
+		if err != nil {
+			fmt.Printf("failed: %v: %v\n", url, err)
+			failed++
+			continue
+		}
+		if result == assign.RecoverRestored {
+			fmt.Printf("restored: %v\n", url)
+		}
+		counts[result]++
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read URL list: %w", err)
+	}
+
+	fmt.Printf("restored: %d, present: %d, unassigned: %d, failed: %d\n", counts[assign.RecoverRestored], counts[assign.RecoverPresent], counts[assign.RecoverUnassigned], failed)
+	if failed > 0 {
+		return fmt.Errorf("%d URLs are not recovered", failed)
+	}
+	return nil
+}